@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,18 +16,23 @@ import (
 
 	"github.com/spf13/pflag"
 
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
 	"github.com/evry-bergen/waf-syncer/pkg/config"
 	"github.com/evry-bergen/waf-syncer/pkg/director"
 
 	istio "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned"
 	istioInformers "github.com/evry-bergen/waf-syncer/pkg/clients/istio/informers/externalversions"
 
-	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2018-12-01/network"
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 var kubeconfig string
@@ -68,7 +76,7 @@ func newGenericClientset(kubeconfig *rest.Config) *kubernetes.Clientset {
 	return cs
 }
 
-func newAzureClient() *azureNetwork.ApplicationGatewaysClient {
+func newAzureClient() azure.ApplicationGatewaysClient {
 	agClient := azureNetwork.NewApplicationGatewaysClient(viper.GetString("azure_subscription_id"))
 
 	// create an authorizer from env vars or Azure Managed Service Idenity
@@ -76,19 +84,136 @@ func newAzureClient() *azureNetwork.ApplicationGatewaysClient {
 	if err == nil {
 		agClient.Authorizer = authorizer
 	}
-	return &agClient
+	return azure.NewClient(agClient)
+}
+
+// Print what a sync would change on the AG, without ever updating it
+func plan(d *director.Director, stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, d.GatewayInformerSynced, d.VirtualServiceInformerSynced) {
+		zap.S().Fatal("timed out waiting for cache sync")
+	}
+
+	// A plan is read-only, problems are printed instead of reported on Gateways
+	d.Recorder = nil
+
+	changes, err := d.Plan()
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	switch viper.GetString(config.PlanOutput) {
+	case "json":
+		err = changes.WriteJSON(os.Stdout)
+	default:
+		err = changes.WriteText(os.Stdout)
+	}
+
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+}
+
+// Respond 200 when the check passes, 503 with the error otherwise
+func probe(check func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// Serve metrics and probes on every replica, waf_syncer_leader tells the leader
+// apart from the followers.
+func serveHTTP(address string, d *director.Director) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", probe(func(r *http.Request) error {
+		return d.Healthy()
+	}))
+	mux.Handle("/readyz", probe(func(r *http.Request) error {
+		return d.Ready(r.Context())
+	}))
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		changes := d.LastChanges()
+		if changes == nil {
+			http.Error(w, "no reconcile has run yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		changes.WriteJSON(w)
+	})
+
+	zap.S().Infof("Serving metrics, probes and changes on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		zap.S().Fatal(err)
+	}
+}
+
+// Only sync from the replica holding the lease, the others keep their
+// informer caches warm so they can take over quickly.
+func runLeaderElection(d *director.Director, leaderConfig *config.LeaderElectionConfig, clientset *kubernetes.Clientset, stopCh <-chan struct{}) {
+	identity, err := os.Hostname()
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, leaderConfig.Namespace, leaderConfig.LeaseName,
+		clientset.CoreV1(), clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            leaderConfig.LeaseName,
+		LeaseDuration:   leaderConfig.LeaseDuration,
+		RenewDeadline:   leaderConfig.RenewDeadline,
+		RetryPeriod:     leaderConfig.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				zap.S().Infof("%s acquired the lease, starting sync", identity)
+				d.Run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-stopCh:
+					zap.S().Info("Released the lease")
+				default:
+					zap.S().Fatalf("%s lost the lease", identity)
+				}
+			},
+			OnNewLeader: func(leader string) {
+				zap.S().Infof("Current leader is %s", leader)
+			},
+		},
+	})
 }
 
 func main() {
 	config.Pflag()
+	pflag.Parse()
 
 	viper.BindPFlags(pflag.CommandLine)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
 	// logger, _ := zap.NewProduction()
 	logger, _ := zap.NewDevelopment()
 	zap.ReplaceGlobals(logger)
 
+	leaderConfig := config.NewLeaderElectionConfig()
+	httpAddress := viper.GetString(config.HTTPAddress)
+
 	// creates the connection
 	kubeConfig := viper.GetString(config.KubeConfig)
 	master := viper.GetString(config.Ks8MasterUrl)
@@ -102,17 +227,45 @@ func main() {
 	clientset := newGenericClientset(config)
 	istioSet := newIstioClientSet(config)
 
-	var azureAgClient *azureNetwork.ApplicationGatewaysClient
-	azureAgClient = newAzureClient()
+	azureAgClient := newAzureClient()
 
 	stopCh := StopCh()
 
 	gatewayInformerFactory := newIstioInformerFactory(config)
 	gatewayInformer := gatewayInformerFactory.Networking().V1alpha3().Gateways()
+	virtualServiceInformer := gatewayInformerFactory.Networking().V1alpha3().VirtualServices()
 
-	director := director.NewDirector(clientset, istioSet, azureAgClient, gatewayInformer)
+	director := director.NewDirector(clientset, istioSet, azureAgClient, gatewayInformer, virtualServiceInformer)
 
 	gatewayInformerFactory.Start(stopCh)
+
+	if pflag.Arg(0) == "plan" {
+		plan(director, stopCh)
+		return
+	}
+
+	/*
+		A dry run shadows the active syncer, which already reports on the
+		Gateways. It must not take the lease either, the active syncer would
+		stop updating the WAF for as long as the shadow held it.
+	*/
+	if director.AzureWafConfig.DryRun {
+		director.Recorder = nil
+		if leaderConfig.Enabled {
+			zap.S().Warn("Dry run, not taking part in leader election")
+			leaderConfig.Enabled = false
+		}
+	}
+
+	if httpAddress != "" {
+		go serveHTTP(httpAddress, director)
+	}
+
+	if leaderConfig.Enabled {
+		runLeaderElection(director, leaderConfig, clientset, stopCh)
+		return
+	}
+
 	director.Run(stopCh)
 	<-stopCh
 }
@@ -0,0 +1,52 @@
+package azure
+
+import (
+	"context"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+)
+
+// ApplicationGatewaysClient - The Application Gateway operations used by the director
+type ApplicationGatewaysClient interface {
+	Get(ctx context.Context, resourceGroupName string, applicationGatewayName string) (azureNetwork.ApplicationGateway, error)
+	CreateOrUpdate(ctx context.Context, resourceGroupName string, applicationGatewayName string, parameters azureNetwork.ApplicationGateway) (UpdateFuture, error)
+}
+
+// UpdateFuture - A long running CreateOrUpdate operation
+type UpdateFuture interface {
+	// WaitForCompletion - Poll the operation until it is done or ctx is cancelled
+	WaitForCompletion(ctx context.Context) error
+	// Result - The Application Gateway as it is after the operation
+	Result() (azureNetwork.ApplicationGateway, error)
+}
+
+type client struct {
+	azureNetwork.ApplicationGatewaysClient
+}
+
+type updateFuture struct {
+	future azureNetwork.ApplicationGatewaysCreateOrUpdateFuture
+	client azureNetwork.ApplicationGatewaysClient
+}
+
+// NewClient - Wrap an Azure SDK Application Gateway client
+func NewClient(agClient azureNetwork.ApplicationGatewaysClient) ApplicationGatewaysClient {
+	return &client{ApplicationGatewaysClient: agClient}
+}
+
+func (c *client) CreateOrUpdate(ctx context.Context, resourceGroupName string, applicationGatewayName string, parameters azureNetwork.ApplicationGateway) (UpdateFuture, error) {
+	future, err := c.ApplicationGatewaysClient.CreateOrUpdate(ctx, resourceGroupName, applicationGatewayName, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updateFuture{future: future, client: c.ApplicationGatewaysClient}, nil
+}
+
+func (f *updateFuture) WaitForCompletion(ctx context.Context) error {
+	return f.future.WaitForCompletionRef(ctx, f.client.Client)
+}
+
+func (f *updateFuture) Result() (azureNetwork.ApplicationGateway, error) {
+	return f.future.Result(f.client)
+}
@@ -0,0 +1,224 @@
+package fake
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	sslMate "software.sslmate.com/src/go-pkcs12"
+
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
+	"github.com/evry-bergen/waf-syncer/pkg/crypto"
+)
+
+// ApplicationGatewaysClient - In-memory Application Gateways, validating updates the way Azure does
+type ApplicationGatewaysClient struct {
+	lock     sync.Mutex
+	gateways map[string]azureNetwork.ApplicationGateway
+	updates  int
+
+	// UpdateError - When set, CreateOrUpdate fails with this error
+	UpdateError error
+}
+
+type updateFuture struct {
+	ag azureNetwork.ApplicationGateway
+}
+
+// NewApplicationGatewaysClient - Creates an empty fake
+func NewApplicationGatewaysClient() *ApplicationGatewaysClient {
+	return &ApplicationGatewaysClient{
+		gateways: map[string]azureNetwork.ApplicationGateway{},
+	}
+}
+
+func key(resourceGroupName string, applicationGatewayName string) string {
+	return strings.ToLower(fmt.Sprintf("%s/%s", resourceGroupName, applicationGatewayName))
+}
+
+// ID - The resource id of an Application Gateway in the fake
+func ID(resourceGroupName string, applicationGatewayName string) string {
+	return fmt.Sprintf("/subscriptions/fake/resourceGroups/%s/providers/Microsoft.Network/applicationGateways/%s",
+		resourceGroupName, applicationGatewayName)
+}
+
+/*
+	JSON of an Application Gateway the way Azure returns it. The SDK leaves the
+	read-only name, etag and type out of requests, responses have them.
+*/
+func marshalGateway(ag azureNetwork.ApplicationGateway) ([]byte, error) {
+	raw, err := json.Marshal(ag)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if ag.Name != nil {
+		fields["name"] = ag.Name
+	}
+	if ag.Etag != nil {
+		fields["etag"] = ag.Etag
+	}
+	if ag.Type != nil {
+		fields["type"] = ag.Type
+	}
+
+	return json.Marshal(fields)
+}
+
+func copyGateway(ag azureNetwork.ApplicationGateway) azureNetwork.ApplicationGateway {
+	raw, err := marshalGateway(ag)
+	if err != nil {
+		panic(err)
+	}
+
+	cp := azureNetwork.ApplicationGateway{}
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		panic(err)
+	}
+
+	return cp
+}
+
+func detailedError(method string, statusCode int, format string, args ...interface{}) error {
+	return autorest.DetailedError{
+		PackageType: "fake.ApplicationGatewaysClient",
+		Method:      method,
+		StatusCode:  statusCode,
+		Message:     fmt.Sprintf(format, args...),
+	}
+}
+
+// Add - Store an Application Gateway without validation
+func (c *ApplicationGatewaysClient) Add(resourceGroupName string, ag azureNetwork.ApplicationGateway) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ag = copyGateway(ag)
+	if ag.ID == nil {
+		ag.ID = to.StringPtr(ID(resourceGroupName, *ag.Name))
+	}
+	if ag.ApplicationGatewayPropertiesFormat != nil && ag.ProvisioningState == "" {
+		ag.ProvisioningState = azureNetwork.Succeeded
+	}
+	setIDs(&ag)
+
+	c.gateways[key(resourceGroupName, *ag.Name)] = ag
+}
+
+// Updates - The number of successful CreateOrUpdate calls
+func (c *ApplicationGatewaysClient) Updates() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.updates
+}
+
+// Get - Get a copy of the stored Application Gateway
+func (c *ApplicationGatewaysClient) Get(ctx context.Context, resourceGroupName string, applicationGatewayName string) (azureNetwork.ApplicationGateway, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ag, ok := c.gateways[key(resourceGroupName, applicationGatewayName)]
+	if !ok {
+		return azureNetwork.ApplicationGateway{}, detailedError("Get", http.StatusNotFound,
+			"application gateway %s not found in %s", applicationGatewayName, resourceGroupName)
+	}
+
+	return copyGateway(ag), nil
+}
+
+// CreateOrUpdate - Validate and store an Application Gateway, the returned future is already done
+func (c *ApplicationGatewaysClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, applicationGatewayName string, parameters azureNetwork.ApplicationGateway) (azure.UpdateFuture, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.UpdateError != nil {
+		return nil, c.UpdateError
+	}
+
+	ag := copyGateway(parameters)
+	ag.Name = to.StringPtr(applicationGatewayName)
+	ag.ID = to.StringPtr(ID(resourceGroupName, applicationGatewayName))
+	if existing, ok := c.gateways[key(resourceGroupName, applicationGatewayName)]; ok {
+		ag.ID = existing.ID
+	}
+
+	if ag.ApplicationGatewayPropertiesFormat == nil {
+		return nil, detailedError("CreateOrUpdate", http.StatusBadRequest, "application gateway has no properties")
+	}
+
+	if err := Validate(&ag); err != nil {
+		return nil, detailedError("CreateOrUpdate", http.StatusBadRequest, err.Error())
+	}
+
+	if err := storeCertificates(&ag); err != nil {
+		return nil, detailedError("CreateOrUpdate", http.StatusBadRequest, err.Error())
+	}
+
+	c.updates++
+	ag.Etag = to.StringPtr(fmt.Sprintf("W/\"%d\"", c.updates))
+	ag.ProvisioningState = azureNetwork.Succeeded
+	setIDs(&ag)
+	c.gateways[key(resourceGroupName, applicationGatewayName)] = ag
+
+	return &updateFuture{ag: copyGateway(ag)}, nil
+}
+
+func (f *updateFuture) WaitForCompletion(ctx context.Context) error {
+	return nil
+}
+
+func (f *updateFuture) Result() (azureNetwork.ApplicationGateway, error) {
+	return copyGateway(f.ag), nil
+}
+
+/*
+	Azure keeps only the public part of uploaded certificates, the PFX itself
+	is never returned.
+*/
+func storeCertificates(ag *azureNetwork.ApplicationGateway) error {
+	if ag.SslCertificates == nil {
+		return nil
+	}
+
+	for i, cert := range *ag.SslCertificates {
+		props := cert.ApplicationGatewaySslCertificatePropertiesFormat
+		if props == nil || props.Data == nil {
+			continue
+		}
+
+		pfx, err := base64.StdEncoding.DecodeString(*props.Data)
+		if err != nil {
+			return fmt.Errorf("certificate %s: %s", to.String(cert.Name), err)
+		}
+
+		_, leaf, caCerts, err := sslMate.DecodeChain(pfx, to.String(props.Password))
+		if err != nil {
+			return fmt.Errorf("certificate %s: %s", to.String(cert.Name), err)
+		}
+
+		publicCertData, err := crypto.EncodePublicCertData(append([]*x509.Certificate{leaf}, caCerts...))
+		if err != nil {
+			return err
+		}
+
+		props.PublicCertData = to.StringPtr(publicCertData)
+		props.Data = nil
+		props.Password = nil
+		(*ag.SslCertificates)[i].ApplicationGatewaySslCertificatePropertiesFormat = props
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package fake
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/magiconair/properties/assert"
+)
+
+func ref(collection string, name string) *azureNetwork.SubResource {
+	return &azureNetwork.SubResource{ID: to.StringPtr(ID("rg", "ag") + "/" + collection + "/" + name)}
+}
+
+func testGateway() azureNetwork.ApplicationGateway {
+	return azureNetwork.ApplicationGateway{
+		Name: to.StringPtr("ag"),
+		ApplicationGatewayPropertiesFormat: &azureNetwork.ApplicationGatewayPropertiesFormat{
+			FrontendIPConfigurations: &[]azureNetwork.ApplicationGatewayFrontendIPConfiguration{{Name: to.StringPtr("ip")}},
+			FrontendPorts:            &[]azureNetwork.ApplicationGatewayFrontendPort{{Name: to.StringPtr("http")}},
+			BackendAddressPools:      &[]azureNetwork.ApplicationGatewayBackendAddressPool{{Name: to.StringPtr("pool")}},
+			BackendHTTPSettingsCollection: &[]azureNetwork.ApplicationGatewayBackendHTTPSettings{
+				{Name: to.StringPtr("settings")},
+			},
+			HTTPListeners: &[]azureNetwork.ApplicationGatewayHTTPListener{{
+				Name: to.StringPtr("listener"),
+				ApplicationGatewayHTTPListenerPropertiesFormat: &azureNetwork.ApplicationGatewayHTTPListenerPropertiesFormat{
+					FrontendIPConfiguration: ref("frontendIPConfigurations", "ip"),
+					FrontendPort:            ref("frontendPorts", "http"),
+					Protocol:                azureNetwork.HTTP,
+					HostName:                to.StringPtr("a.example.com"),
+				},
+			}},
+			RequestRoutingRules: &[]azureNetwork.ApplicationGatewayRequestRoutingRule{{
+				Name: to.StringPtr("rule"),
+				ApplicationGatewayRequestRoutingRulePropertiesFormat: &azureNetwork.ApplicationGatewayRequestRoutingRulePropertiesFormat{
+					RuleType:            azureNetwork.Basic,
+					HTTPListener:        ref("httpListeners", "listener"),
+					BackendAddressPool:  ref("backendAddressPools", "pool"),
+					BackendHTTPSettings: ref("backendHttpSettingsCollection", "settings"),
+				},
+			}},
+		},
+	}
+}
+
+func TestApplicationGatewaysClient_CreateOrUpdate_should_store(t *testing.T) {
+	c := NewApplicationGatewaysClient()
+	c.Add("rg", testGateway())
+
+	ag, err := c.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, *(*ag.FrontendPorts)[0].ID, ID("rg", "ag")+"/frontendPorts/http")
+
+	future, err := c.CreateOrUpdate(context.Background(), "rg", "ag", ag)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, future.WaitForCompletion(context.Background()), nil)
+	assert.Equal(t, c.Updates(), 1)
+}
+
+func TestApplicationGatewaysClient_CreateOrUpdate_should_validate_references(t *testing.T) {
+	c := NewApplicationGatewaysClient()
+	c.Add("rg", testGateway())
+
+	for name, breakIt := range map[string]func(ag *azureNetwork.ApplicationGateway){
+		"listener port": func(ag *azureNetwork.ApplicationGateway) {
+			(*ag.HTTPListeners)[0].FrontendPort = ref("frontendPorts", "missing")
+		},
+		"listener certificate": func(ag *azureNetwork.ApplicationGateway) {
+			(*ag.HTTPListeners)[0].Protocol = azureNetwork.HTTPS
+			(*ag.HTTPListeners)[0].SslCertificate = ref("sslCertificates", "missing")
+		},
+		"rule pool": func(ag *azureNetwork.ApplicationGateway) {
+			(*ag.RequestRoutingRules)[0].BackendAddressPool = ref("backendAddressPools", "missing")
+		},
+		"rule listener": func(ag *azureNetwork.ApplicationGateway) {
+			(*ag.RequestRoutingRules)[0].HTTPListener = ref("httpListeners", "missing")
+		},
+		"duplicate host": func(ag *azureNetwork.ApplicationGateway) {
+			listeners := append(*ag.HTTPListeners, (*ag.HTTPListeners)[0])
+			listeners[1].Name = to.StringPtr("other")
+			ag.HTTPListeners = &listeners
+		},
+		"duplicate host in host names": func(ag *azureNetwork.ApplicationGateway) {
+			listeners := append(*ag.HTTPListeners, (*ag.HTTPListeners)[0])
+			props := *listeners[1].ApplicationGatewayHTTPListenerPropertiesFormat
+			props.HostName = nil
+			props.HostNames = &[]string{"b.example.com", "A.example.com"}
+			listeners[1].Name = to.StringPtr("other")
+			listeners[1].ApplicationGatewayHTTPListenerPropertiesFormat = &props
+			ag.HTTPListeners = &listeners
+		},
+	} {
+		ag, _ := c.Get(context.Background(), "rg", "ag")
+		breakIt(&ag)
+
+		_, err := c.CreateOrUpdate(context.Background(), "rg", "ag", ag)
+		assert.Equal(t, err != nil, true, name)
+	}
+
+	assert.Equal(t, c.Updates(), 0)
+}
+
+func TestApplicationGatewaysClient_Get_should_fail_for_unknown_gateway(t *testing.T) {
+	c := NewApplicationGatewaysClient()
+
+	_, err := c.Get(context.Background(), "rg", "missing")
+	assert.Equal(t, strings.Contains(err.Error(), "StatusCode=404"), true)
+}
@@ -0,0 +1,213 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+var (
+	gatewayPath   = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/([^/]+)/providers/Microsoft\.Network/applicationGateways/([^/]+)$`)
+	operationPath = regexp.MustCompile(`^/operations/([0-9]+)$`)
+)
+
+/*
+	Server - An ARM compatible stand-in for the Microsoft.Network/applicationGateways
+	GET and PUT endpoints, including the long running operation a PUT starts.
+	Gateways are stored in, and validated by, an ApplicationGatewaysClient.
+*/
+type Server struct {
+	*httptest.Server
+	Gateways *ApplicationGatewaysClient
+
+	// Polls - How often an operation reports InProgress before it succeeds
+	Polls int
+
+	lock          sync.Mutex
+	operations    map[string]*operation
+	nextOperation int
+	requests      map[string]int
+}
+
+type operation struct {
+	gateway string
+	polls   int
+}
+
+type armError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewServer - Start a server backed by the given gateways, Close it when done
+func NewServer(gateways *ApplicationGatewaysClient) *Server {
+	s := &Server{
+		Gateways:   gateways,
+		operations: map[string]*operation{},
+		requests:   map[string]int{},
+	}
+	s.Server = httptest.NewServer(s)
+
+	return s
+}
+
+// Requests - Number of requests served for the given method
+func (s *Server) Requests(method string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.requests[method]
+}
+
+// Client - An Azure SDK client talking to this server
+func (s *Server) Client() azureNetwork.ApplicationGatewaysClient {
+	client := azureNetwork.NewApplicationGatewaysClientWithBaseURI(s.URL, "fake")
+	client.Authorizer = autorest.NullAuthorizer{}
+	client.RetryAttempts = 1
+
+	return client
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests[r.Method]++
+	s.lock.Unlock()
+
+	if match := operationPath.FindStringSubmatch(r.URL.Path); match != nil && r.Method == http.MethodGet {
+		s.getOperation(w, match[1])
+		return
+	}
+
+	match := gatewayPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		writeError(w, http.StatusNotFound, "InvalidResourceType", fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getGateway(w, r, match[1], match[2])
+	case http.MethodPut:
+		s.putGateway(w, r, match[1], match[2])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeGateway(w http.ResponseWriter, status int, ag azureNetwork.ApplicationGateway) {
+	body, err := marshalGateway(ag)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	body := armError{}
+	body.Error.Code = code
+	body.Error.Message = message
+	writeJSON(w, status, body)
+}
+
+/*
+	While an operation on a gateway is running Azure reports it as Updating
+*/
+func (s *Server) updating(gateway string) bool {
+	for _, op := range s.operations {
+		if op.gateway == gateway {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) getGateway(w http.ResponseWriter, r *http.Request, resourceGroupName string, name string) {
+	ag, err := s.Gateways.Get(r.Context(), resourceGroupName, name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.updating(key(resourceGroupName, name)) {
+		ag.ProvisioningState = azureNetwork.Updating
+	}
+
+	writeGateway(w, http.StatusOK, ag)
+}
+
+func (s *Server) putGateway(w http.ResponseWriter, r *http.Request, resourceGroupName string, name string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+
+	ag := azureNetwork.ApplicationGateway{}
+	if err := json.Unmarshal(body, &ag); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+
+	future, err := s.Gateways.CreateOrUpdate(r.Context(), resourceGroupName, name, ag)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidResourceReference", err.Error())
+		return
+	}
+
+	result, _ := future.Result()
+	result.ProvisioningState = azureNetwork.Updating
+
+	s.lock.Lock()
+	s.nextOperation++
+	id := fmt.Sprintf("%d", s.nextOperation)
+	s.operations[id] = &operation{gateway: key(resourceGroupName, name)}
+	s.lock.Unlock()
+
+	w.Header().Set("Azure-AsyncOperation", fmt.Sprintf("%s/operations/%s", s.URL, id))
+	w.Header().Set("Retry-After", "0")
+	writeGateway(w, http.StatusCreated, result)
+}
+
+func (s *Server) getOperation(w http.ResponseWriter, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	op, ok := s.operations[id]
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "Succeeded"})
+		return
+	}
+
+	w.Header().Set("Retry-After", "0")
+	if op.polls < s.Polls {
+		op.polls++
+		writeJSON(w, http.StatusOK, map[string]string{"status": "InProgress"})
+		return
+	}
+
+	delete(s.operations, id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "Succeeded"})
+}
@@ -0,0 +1,53 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/magiconair/properties/assert"
+
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
+)
+
+func TestServer_should_serve_the_sdk_client(t *testing.T) {
+	gateways := NewApplicationGatewaysClient()
+	gateways.Add("rg", testGateway())
+
+	server := NewServer(gateways)
+	server.Polls = 2
+	defer server.Close()
+
+	client := azure.NewClient(server.Client())
+	ag, err := client.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ag.ProvisioningState, azureNetwork.Succeeded)
+
+	future, err := client.CreateOrUpdate(context.Background(), "rg", "ag", ag)
+	assert.Equal(t, err, nil)
+
+	updating, _ := client.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, updating.ProvisioningState, azureNetwork.Updating)
+
+	assert.Equal(t, future.WaitForCompletion(context.Background()), nil)
+	result, err := future.Result()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.ProvisioningState, azureNetwork.Succeeded)
+	assert.Equal(t, gateways.Updates(), 1)
+}
+
+func TestServer_should_reject_invalid_updates(t *testing.T) {
+	gateways := NewApplicationGatewaysClient()
+	gateways.Add("rg", testGateway())
+
+	server := NewServer(gateways)
+	defer server.Close()
+
+	client := azure.NewClient(server.Client())
+	ag, _ := client.Get(context.Background(), "rg", "ag")
+	(*ag.RequestRoutingRules)[0].BackendAddressPool = ref("backendAddressPools", "missing")
+
+	_, err := client.CreateOrUpdate(context.Background(), "rg", "ag", ag)
+	assert.Equal(t, err != nil, true)
+	assert.Equal(t, gateways.Updates(), 0)
+}
@@ -0,0 +1,368 @@
+package fake
+
+import (
+	"fmt"
+	"strings"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	frontendIPConfigurations      = "frontendIPConfigurations"
+	frontendPorts                 = "frontendPorts"
+	backendAddressPools           = "backendAddressPools"
+	backendHTTPSettingsCollection = "backendHttpSettingsCollection"
+	httpListeners                 = "httpListeners"
+	sslCertificates               = "sslCertificates"
+	requestRoutingRules           = "requestRoutingRules"
+	redirectConfigurations        = "redirectConfigurations"
+	urlPathMaps                   = "urlPathMaps"
+)
+
+// Names of the sub-resources of an AG, by lower case collection and name
+type resources map[string]map[string]bool
+
+func (r resources) add(collection string, name *string) error {
+	if name == nil || *name == "" {
+		return fmt.Errorf("%s: resource without a name", collection)
+	}
+
+	c := strings.ToLower(collection)
+	if r[c] == nil {
+		r[c] = map[string]bool{}
+	}
+
+	if r[c][strings.ToLower(*name)] {
+		return fmt.Errorf("%s: duplicate name %s", collection, *name)
+	}
+
+	r[c][strings.ToLower(*name)] = true
+	return nil
+}
+
+/*
+	Check that ref points to an existing resource in the given collection of
+	the same AG. ARM ids are case insensitive.
+*/
+func (r resources) check(agID string, collection string, ref *azureNetwork.SubResource, owner string) error {
+	if ref == nil || ref.ID == nil {
+		return fmt.Errorf("%s: missing reference to %s", owner, collection)
+	}
+
+	id := strings.ToLower(*ref.ID)
+	prefix := strings.ToLower(fmt.Sprintf("%s/%s/", agID, collection))
+	if !strings.HasPrefix(id, prefix) || !r[strings.ToLower(collection)][strings.TrimPrefix(id, prefix)] {
+		return fmt.Errorf("%s: reference to non-existing resource %s", owner, *ref.ID)
+	}
+
+	return nil
+}
+
+func collectResources(ag *azureNetwork.ApplicationGateway) (resources, error) {
+	r := resources{}
+	names := map[string][]*string{}
+
+	if ag.FrontendIPConfigurations != nil {
+		for _, i := range *ag.FrontendIPConfigurations {
+			names[frontendIPConfigurations] = append(names[frontendIPConfigurations], i.Name)
+		}
+	}
+	if ag.FrontendPorts != nil {
+		for _, i := range *ag.FrontendPorts {
+			names[frontendPorts] = append(names[frontendPorts], i.Name)
+		}
+	}
+	if ag.BackendAddressPools != nil {
+		for _, i := range *ag.BackendAddressPools {
+			names[backendAddressPools] = append(names[backendAddressPools], i.Name)
+		}
+	}
+	if ag.BackendHTTPSettingsCollection != nil {
+		for _, i := range *ag.BackendHTTPSettingsCollection {
+			names[backendHTTPSettingsCollection] = append(names[backendHTTPSettingsCollection], i.Name)
+		}
+	}
+	if ag.HTTPListeners != nil {
+		for _, i := range *ag.HTTPListeners {
+			names[httpListeners] = append(names[httpListeners], i.Name)
+		}
+	}
+	if ag.SslCertificates != nil {
+		for _, i := range *ag.SslCertificates {
+			names[sslCertificates] = append(names[sslCertificates], i.Name)
+		}
+	}
+	if ag.RequestRoutingRules != nil {
+		for _, i := range *ag.RequestRoutingRules {
+			names[requestRoutingRules] = append(names[requestRoutingRules], i.Name)
+		}
+	}
+	if ag.RedirectConfigurations != nil {
+		for _, i := range *ag.RedirectConfigurations {
+			names[redirectConfigurations] = append(names[redirectConfigurations], i.Name)
+		}
+	}
+	if ag.URLPathMaps != nil {
+		for _, i := range *ag.URLPathMaps {
+			names[urlPathMaps] = append(names[urlPathMaps], i.Name)
+		}
+	}
+
+	for collection, list := range names {
+		for _, name := range list {
+			if err := r.add(collection, name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Validate - Check the references between the sub-resources of an AG like Azure does on update
+func Validate(ag *azureNetwork.ApplicationGateway) error {
+	r, err := collectResources(ag)
+	if err != nil {
+		return err
+	}
+	agID := to.String(ag.ID)
+
+	if ag.SslCertificates != nil {
+		for _, cert := range *ag.SslCertificates {
+			props := cert.ApplicationGatewaySslCertificatePropertiesFormat
+			if props == nil || (props.Data == nil && props.PublicCertData == nil && props.KeyVaultSecretID == nil) {
+				return fmt.Errorf("certificate %s: no certificate data", *cert.Name)
+			}
+		}
+	}
+
+	if err := validateListeners(ag, agID, r); err != nil {
+		return err
+	}
+
+	if ag.RedirectConfigurations != nil {
+		for _, redirect := range *ag.RedirectConfigurations {
+			owner := fmt.Sprintf("redirect configuration %s", *redirect.Name)
+			props := redirect.ApplicationGatewayRedirectConfigurationPropertiesFormat
+			if props == nil || (props.TargetListener == nil && props.TargetURL == nil) {
+				return fmt.Errorf("%s: no redirect target", owner)
+			}
+			if props.TargetListener != nil {
+				if err := r.check(agID, httpListeners, props.TargetListener, owner); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if ag.URLPathMaps != nil {
+		for _, pathMap := range *ag.URLPathMaps {
+			if err := validatePathMap(pathMap, agID, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return validateRules(ag, agID, r)
+}
+
+func validateListeners(ag *azureNetwork.ApplicationGateway, agID string, r resources) error {
+	if ag.HTTPListeners == nil {
+		return nil
+	}
+
+	hosts := map[string]string{}
+	for _, listener := range *ag.HTTPListeners {
+		owner := fmt.Sprintf("listener %s", *listener.Name)
+		props := listener.ApplicationGatewayHTTPListenerPropertiesFormat
+		if props == nil {
+			return fmt.Errorf("%s: no properties", owner)
+		}
+
+		if err := r.check(agID, frontendIPConfigurations, props.FrontendIPConfiguration, owner); err != nil {
+			return err
+		}
+		if err := r.check(agID, frontendPorts, props.FrontendPort, owner); err != nil {
+			return err
+		}
+
+		if props.Protocol == azureNetwork.HTTPS {
+			if err := r.check(agID, sslCertificates, props.SslCertificate, owner); err != nil {
+				return err
+			}
+		}
+
+		if props.HostName != nil && props.HostNames != nil && len(*props.HostNames) > 0 {
+			return fmt.Errorf("%s: both a host name and host names", owner)
+		}
+		listenerHosts := []string{to.String(props.HostName)}
+		if props.HostNames != nil && len(*props.HostNames) > 0 {
+			if len(*props.HostNames) > 5 {
+				return fmt.Errorf("%s: more than 5 host names", owner)
+			}
+			listenerHosts = *props.HostNames
+		}
+
+		/* Only one listener per frontend port and host name */
+		for _, host := range listenerHosts {
+			binding := strings.ToLower(fmt.Sprintf("%s|%s", to.String(props.FrontendPort.ID), host))
+			if other, ok := hosts[binding]; ok {
+				return fmt.Errorf("%s: host %s on the same port as listener %s", owner, host, other)
+			}
+			hosts[binding] = *listener.Name
+		}
+	}
+
+	return nil
+}
+
+func validatePathMap(pathMap azureNetwork.ApplicationGatewayURLPathMap, agID string, r resources) error {
+	owner := fmt.Sprintf("url path map %s", *pathMap.Name)
+	props := pathMap.ApplicationGatewayURLPathMapPropertiesFormat
+	if props == nil {
+		return fmt.Errorf("%s: no properties", owner)
+	}
+
+	if props.DefaultRedirectConfiguration != nil {
+		if err := r.check(agID, redirectConfigurations, props.DefaultRedirectConfiguration, owner); err != nil {
+			return err
+		}
+	} else {
+		if err := r.check(agID, backendAddressPools, props.DefaultBackendAddressPool, owner); err != nil {
+			return err
+		}
+		if err := r.check(agID, backendHTTPSettingsCollection, props.DefaultBackendHTTPSettings, owner); err != nil {
+			return err
+		}
+	}
+
+	if props.PathRules == nil {
+		return nil
+	}
+
+	for _, rule := range *props.PathRules {
+		ruleOwner := fmt.Sprintf("%s path rule %s", owner, to.String(rule.Name))
+		ruleProps := rule.ApplicationGatewayPathRulePropertiesFormat
+		if ruleProps == nil || ruleProps.Paths == nil || len(*ruleProps.Paths) == 0 {
+			return fmt.Errorf("%s: no paths", ruleOwner)
+		}
+
+		if ruleProps.RedirectConfiguration != nil {
+			if err := r.check(agID, redirectConfigurations, ruleProps.RedirectConfiguration, ruleOwner); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := r.check(agID, backendAddressPools, ruleProps.BackendAddressPool, ruleOwner); err != nil {
+			return err
+		}
+		if err := r.check(agID, backendHTTPSettingsCollection, ruleProps.BackendHTTPSettings, ruleOwner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateRules(ag *azureNetwork.ApplicationGateway, agID string, r resources) error {
+	if ag.RequestRoutingRules == nil {
+		return nil
+	}
+
+	usedListeners := map[string]string{}
+	for _, rule := range *ag.RequestRoutingRules {
+		owner := fmt.Sprintf("routing rule %s", *rule.Name)
+		props := rule.ApplicationGatewayRequestRoutingRulePropertiesFormat
+		if props == nil {
+			return fmt.Errorf("%s: no properties", owner)
+		}
+
+		if err := r.check(agID, httpListeners, props.HTTPListener, owner); err != nil {
+			return err
+		}
+
+		listener := strings.ToLower(*props.HTTPListener.ID)
+		if other, ok := usedListeners[listener]; ok {
+			return fmt.Errorf("%s: listener already used by routing rule %s", owner, other)
+		}
+		usedListeners[listener] = *rule.Name
+
+		switch {
+		case props.RuleType == azureNetwork.PathBasedRouting:
+			if err := r.check(agID, urlPathMaps, props.URLPathMap, owner); err != nil {
+				return err
+			}
+		case props.RedirectConfiguration != nil:
+			if err := r.check(agID, redirectConfigurations, props.RedirectConfiguration, owner); err != nil {
+				return err
+			}
+		default:
+			if err := r.check(agID, backendAddressPools, props.BackendAddressPool, owner); err != nil {
+				return err
+			}
+			if err := r.check(agID, backendHTTPSettingsCollection, props.BackendHTTPSettings, owner); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+	Give every sub-resource the id Azure would, so references to them can be
+	taken from a Get
+*/
+func setIDs(ag *azureNetwork.ApplicationGateway) {
+	id := func(collection string, name *string) *string {
+		return to.StringPtr(fmt.Sprintf("%s/%s/%s", to.String(ag.ID), collection, to.String(name)))
+	}
+
+	if ag.FrontendIPConfigurations != nil {
+		for i, r := range *ag.FrontendIPConfigurations {
+			(*ag.FrontendIPConfigurations)[i].ID = id(frontendIPConfigurations, r.Name)
+		}
+	}
+	if ag.FrontendPorts != nil {
+		for i, r := range *ag.FrontendPorts {
+			(*ag.FrontendPorts)[i].ID = id(frontendPorts, r.Name)
+		}
+	}
+	if ag.BackendAddressPools != nil {
+		for i, r := range *ag.BackendAddressPools {
+			(*ag.BackendAddressPools)[i].ID = id(backendAddressPools, r.Name)
+		}
+	}
+	if ag.BackendHTTPSettingsCollection != nil {
+		for i, r := range *ag.BackendHTTPSettingsCollection {
+			(*ag.BackendHTTPSettingsCollection)[i].ID = id(backendHTTPSettingsCollection, r.Name)
+		}
+	}
+	if ag.HTTPListeners != nil {
+		for i, r := range *ag.HTTPListeners {
+			(*ag.HTTPListeners)[i].ID = id(httpListeners, r.Name)
+		}
+	}
+	if ag.SslCertificates != nil {
+		for i, r := range *ag.SslCertificates {
+			(*ag.SslCertificates)[i].ID = id(sslCertificates, r.Name)
+		}
+	}
+	if ag.RequestRoutingRules != nil {
+		for i, r := range *ag.RequestRoutingRules {
+			(*ag.RequestRoutingRules)[i].ID = id(requestRoutingRules, r.Name)
+		}
+	}
+	if ag.RedirectConfigurations != nil {
+		for i, r := range *ag.RedirectConfigurations {
+			(*ag.RedirectConfigurations)[i].ID = id(redirectConfigurations, r.Name)
+		}
+	}
+	if ag.URLPathMaps != nil {
+		for i, r := range *ag.URLPathMaps {
+			(*ag.URLPathMaps)[i].ID = id(urlPathMaps, r.Name)
+		}
+	}
+}
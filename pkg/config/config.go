@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -9,10 +11,25 @@ const (
 	AzureWafListenerPrefix      = "azure_waf_listener_prefix"
 	azureWafBackendHttpSettings = "azure_waf_backend_http_settings"
 	azureWafFrontendPort        = "azure_waf_frontend_port"
+	azureWafHTTPFrontendPort    = "azure_waf_http_frontend_port"
 	AzureWafBackendPool         = "azure_waf_backend_pool"
 	AzureWafName                = "azure_waf_name"
 	AzureWafRg                  = "azure_waf_rg"
 	azureSubscriptionId         = "azure_subscription_id"
+	azureWafSyncDebounce        = "azure_waf_sync_debounce"
+	PlanOutput                  = "output"
+	leaderElect                 = "leader-elect"
+	leaderElectLeaseName        = "leader-elect-lease-name"
+	leaderElectNamespace        = "leader-elect-namespace"
+	leaderElectLeaseDuration    = "leader-elect-lease-duration"
+	leaderElectRenewDeadline    = "leader-elect-renew-deadline"
+	leaderElectRetryPeriod      = "leader-elect-retry-period"
+	dryRun                      = "dry-run"
+	HTTPAddress                 = "http-address"
+	livenessDeadline            = "liveness-deadline"
+	certExpiryWarning           = "cert-expiry-warning"
+	rejectExpiredCerts          = "reject-expired-certs"
+	multiHostListeners          = "multi-host-listeners"
 	Ks8MasterUrl                = "ks8MasterUrl"
 	KubeConfig                  = "KubeConfig"
 )
@@ -21,10 +38,26 @@ type AzureWafConfig struct {
 	ListenerPrefix      string
 	BackendHttpSettings string
 	FrontendPort        string
+	HTTPFrontendPort    string
 	BackendPool         string
 	Name                string
 	ResourceGroup       string
 	SubscriptionID      string
+	SyncDebounce        time.Duration
+	DryRun              bool
+	LivenessDeadline    time.Duration
+	CertExpiryWarning   time.Duration
+	RejectExpiredCerts  bool
+	MultiHostListeners  bool
+}
+
+type LeaderElectionConfig struct {
+	Enabled       bool
+	LeaseName     string
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
 }
 
 type Ks8Config struct {
@@ -37,14 +70,33 @@ func NewAzureConfig() *AzureWafConfig {
 		ListenerPrefix:      viper.GetString(AzureWafListenerPrefix),
 		BackendHttpSettings: viper.GetString(azureWafBackendHttpSettings),
 		FrontendPort:        viper.GetString(azureWafFrontendPort),
+		HTTPFrontendPort:    viper.GetString(azureWafHTTPFrontendPort),
 		BackendPool:         viper.GetString(AzureWafBackendPool),
 		Name:                viper.GetString(AzureWafName),
 		ResourceGroup:       viper.GetString(AzureWafRg),
 		SubscriptionID:      "",
+		SyncDebounce:        viper.GetDuration(azureWafSyncDebounce),
+		DryRun:              viper.GetBool(dryRun),
+		LivenessDeadline:    viper.GetDuration(livenessDeadline),
+		CertExpiryWarning:   viper.GetDuration(certExpiryWarning),
+		RejectExpiredCerts:  viper.GetBool(rejectExpiredCerts),
+		MultiHostListeners:  viper.GetBool(multiHostListeners),
 	}
 	return &a
 }
 
+func NewLeaderElectionConfig() *LeaderElectionConfig {
+	l := LeaderElectionConfig{
+		Enabled:       viper.GetBool(leaderElect),
+		LeaseName:     viper.GetString(leaderElectLeaseName),
+		Namespace:     viper.GetString(leaderElectNamespace),
+		LeaseDuration: viper.GetDuration(leaderElectLeaseDuration),
+		RenewDeadline: viper.GetDuration(leaderElectRenewDeadline),
+		RetryPeriod:   viper.GetDuration(leaderElectRetryPeriod),
+	}
+	return &l
+}
+
 func Pflag() {
 	pflag.String(KubeConfig, "", "ABS path to KubeConfig")
 	pflag.String(Ks8MasterUrl, "", "k8s master url")
@@ -53,6 +105,21 @@ func Pflag() {
 	pflag.String(AzureWafName, "", "The AG / WAF instance to use")
 	pflag.String(AzureWafBackendPool, "", "The AG / WAF backend pool")
 	pflag.String(azureWafFrontendPort, "https", "The AG / WAF frontend port name")
+	pflag.String(azureWafHTTPFrontendPort, "http", "The AG / WAF frontend port name used for HTTP to HTTPS redirects")
 	pflag.String(azureWafBackendHttpSettings, "", "The AG / WAF backend http settings name")
 	pflag.String(AzureWafListenerPrefix, "wd", "Prefix all WAF Director listeners with this")
+	pflag.Bool(leaderElect, false, "Only sync the AG / WAF from the replica holding the leader election lease")
+	pflag.String(leaderElectLeaseName, "waf-syncer", "Name of the leader election lease")
+	pflag.String(leaderElectNamespace, "kube-system", "Namespace of the leader election lease")
+	pflag.Duration(leaderElectLeaseDuration, 15*time.Second, "How long followers wait before trying to take over the lease")
+	pflag.Duration(leaderElectRenewDeadline, 10*time.Second, "How long the leader keeps retrying to renew the lease before giving up")
+	pflag.Duration(leaderElectRetryPeriod, 2*time.Second, "How long to wait between leader election attempts")
+	pflag.Bool(dryRun, false, "Compute and log the changes to the AG / WAF without applying them")
+	pflag.String(PlanOutput, "text", "Output format of the plan command, text or json")
+	pflag.String(HTTPAddress, ":8080", "Address to serve /metrics, /healthz and /readyz on, empty to disable")
+	pflag.Duration(livenessDeadline, 30*time.Minute, "Report unhealthy when a single sync of the AG / WAF runs longer than this")
+	pflag.Duration(certExpiryWarning, 14*24*time.Hour, "Warn on Gateways whose certificate expires within this, 0 to disable")
+	pflag.Bool(rejectExpiredCerts, false, "Leave servers with an expired certificate out of the AG / WAF instead of syncing it")
+	pflag.Bool(multiHostListeners, false, "Sync the hosts of a Gateway server to one listener with a host names list instead of a listener per host")
+	pflag.Duration(azureWafSyncDebounce, 5*time.Second, "Wait this long for related changes before updating the AG / WAF")
 }
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"bytes"
+	stdCrypto "crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoCertificate - tls.crt does not contain a single PEM encoded certificate
+	ErrNoCertificate = errors.New("no PEM encoded certificate found in tls.crt")
+	// ErrKeyMismatch - tls.key is not the private key of any certificate in tls.crt
+	ErrKeyMismatch = errors.New("private key does not match any certificate in tls.crt")
+)
+
+// ChainError - The certificates in tls.crt don't form a chain up from the leaf
+type ChainError struct {
+	Certificate *x509.Certificate
+	Reason      string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("broken certificate chain at %q: %s", e.Certificate.Subject.String(), e.Reason)
+}
+
+func publicKeysEqual(a interface{}, b interface{}) bool {
+	aDer, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+
+	bDer, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(aDer, bDer)
+}
+
+/*
+	The certificate belonging to the private key. Preferably one that is not a
+	CA, a self-signed CA serving as its own leaf is accepted as last resort.
+*/
+func leafForKey(certs []*x509.Certificate, key stdCrypto.Signer) (*x509.Certificate, error) {
+	var leaf *x509.Certificate
+	for _, cert := range certs {
+		if !publicKeysEqual(cert.PublicKey, key.Public()) {
+			continue
+		}
+		if !cert.IsCA {
+			return cert, nil
+		}
+		if leaf == nil {
+			leaf = cert
+		}
+	}
+
+	if leaf == nil {
+		return nil, ErrKeyMismatch
+	}
+
+	return leaf, nil
+}
+
+func selfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+/*
+	OrderChain - Find the leaf of the private key and order the other certificates
+	into the chain of issuers above it, each verified to have signed the one
+	below. The chain may stop below the root, as roots are usually left out, but
+	every certificate has to be part of it.
+*/
+func OrderChain(certs []*x509.Certificate, key stdCrypto.Signer) (*x509.Certificate, []*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, nil, ErrNoCertificate
+	}
+
+	leaf, err := leafForKey(certs, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remaining := []*x509.Certificate{}
+	for _, cert := range certs {
+		if cert != leaf {
+			remaining = append(remaining, cert)
+		}
+	}
+
+	chain := []*x509.Certificate{}
+	current := leaf
+	for len(remaining) > 0 && !selfSigned(current) {
+		found := -1
+		for i, candidate := range remaining {
+			if !bytes.Equal(candidate.RawSubject, current.RawIssuer) {
+				continue
+			}
+			if err := current.CheckSignatureFrom(candidate); err != nil {
+				return nil, nil, &ChainError{Certificate: current, Reason: fmt.Sprintf("not signed by its issuer: %s", err)}
+			}
+			found = i
+			break
+		}
+
+		if found < 0 {
+			break
+		}
+
+		current = remaining[found]
+		chain = append(chain, current)
+		remaining = append(remaining[:found], remaining[found+1:]...)
+	}
+
+	if len(remaining) > 0 {
+		return nil, nil, &ChainError{Certificate: current, Reason: fmt.Sprintf("%d certificate(s) not part of the chain", len(remaining))}
+	}
+
+	return leaf, chain, nil
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+type testIssuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+/*
+	Create a certificate for a fresh key, signed by the issuer or self-signed
+	when the issuer is nil
+*/
+func testCertificate(t *testing.T, name string, ca bool, issuer *testIssuer) *testIssuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testIssuer{cert: cert, key: key}
+}
+
+func TestOrderChain_should_order_intermediates(t *testing.T) {
+	root := testCertificate(t, "root", true, nil)
+	intermediate := testCertificate(t, "intermediate", true, root)
+	leaf := testCertificate(t, "a.example.com", false, intermediate)
+
+	gotLeaf, chain, err := OrderChain([]*x509.Certificate{root.cert, leaf.cert, intermediate.cert}, leaf.key)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, gotLeaf, leaf.cert)
+	assert.Equal(t, chain, []*x509.Certificate{intermediate.cert, root.cert})
+
+	gotLeaf, chain, err = OrderChain([]*x509.Certificate{leaf.cert}, leaf.key)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, gotLeaf, leaf.cert)
+	assert.Equal(t, len(chain), 0)
+}
+
+func TestOrderChain_should_fail_when_key_does_not_match(t *testing.T) {
+	root := testCertificate(t, "root", true, nil)
+	leaf := testCertificate(t, "a.example.com", false, root)
+
+	_, _, err := OrderChain([]*x509.Certificate{leaf.cert, root.cert}, testCertificate(t, "other", false, nil).key)
+	assert.Equal(t, err, ErrKeyMismatch)
+
+	_, _, err = OrderChain([]*x509.Certificate{}, leaf.key)
+	assert.Equal(t, err, ErrNoCertificate)
+}
+
+func TestOrderChain_should_fail_on_broken_chain(t *testing.T) {
+	root := testCertificate(t, "root", true, nil)
+	intermediate := testCertificate(t, "intermediate", true, root)
+	leaf := testCertificate(t, "a.example.com", false, intermediate)
+
+	_, _, err := OrderChain([]*x509.Certificate{leaf.cert, root.cert}, leaf.key)
+	chainErr, ok := err.(*ChainError)
+	assert.Equal(t, ok, true, "missing intermediate should be a ChainError")
+	assert.Equal(t, chainErr.Certificate, leaf.cert)
+
+	/* Same subject as the intermediate, but a different key */
+	impostor := testCertificate(t, "intermediate", true, root)
+	_, _, err = OrderChain([]*x509.Certificate{leaf.cert, impostor.cert}, leaf.key)
+	_, ok = err.(*ChainError)
+	assert.Equal(t, ok, true, "wrong signature should be a ChainError")
+}
+
+func TestSecretWrapper_ContentHash_should_include_the_chain(t *testing.T) {
+	root := testCertificate(t, "root", true, nil)
+	leaf := testCertificate(t, "leaf", false, root)
+
+	alone := &SecretWrapper{Certificates: []*x509.Certificate{leaf.cert}}
+	chained := &SecretWrapper{Certificates: []*x509.Certificate{leaf.cert}, CACertificates: []*x509.Certificate{root.cert}}
+
+	assert.Equal(t, alone.ContentHash() == chained.ContentHash(), false)
+	assert.Equal(t, alone.ContentHash(), (&SecretWrapper{Certificates: []*x509.Certificate{leaf.cert}}).ContentHash())
+}
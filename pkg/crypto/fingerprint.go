@@ -0,0 +1,145 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	errNoCertificates = errors.New("no certificates in public cert data")
+
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// Fingerprint - Hex encoded SHA-256 of the DER encoded certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fingerprint - Fingerprint of the leaf certificate
+func (w *SecretWrapper) Fingerprint() string {
+	return Fingerprint(w.Certificates[0])
+}
+
+// ContentHash - Hex encoded SHA-256 over the leaf and its chain, in order
+func (w *SecretWrapper) ContentHash() string {
+	hash := sha256.New()
+	for _, cert := range append([]*x509.Certificate{w.Certificates[0]}, w.CACertificates...) {
+		hash.Write(cert.Raw)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+	ParsePublicCertData - Parse the base64 encoded PKCS#7 bundle Azure returns
+	as publicCertData for an AG certificate
+*/
+func ParsePublicCertData(data string) ([]*x509.Certificate, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(raw, &info); err != nil {
+		return nil, err
+	}
+
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, err
+	}
+
+	certs, err := x509.ParseCertificates(signedData.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, errNoCertificates
+	}
+
+	return certs, nil
+}
+
+/*
+	EncodePublicCertData - Encode certificates as a degenerate PKCS#7 bundle, the
+	inverse of ParsePublicCertData
+*/
+func EncodePublicCertData(certs []*x509.Certificate) (string, error) {
+	raw := []byte{}
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms []asn1.RawValue `asn1:"set"`
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      []asn1.RawValue `asn1:"set"`
+	}{
+		Version:      1,
+		ContentInfo:  struct{ ContentType asn1.ObjectIdentifier }{oidData},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	contentInfo, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(contentInfo), nil
+}
+
+/*
+	LeafCertificate - The certificate in the bundle that did not issue any of
+	the others
+*/
+func LeafCertificate(certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		issuer := false
+		for _, other := range certs {
+			if cert != other && bytes.Equal(other.RawIssuer, cert.RawSubject) {
+				issuer = true
+				break
+			}
+		}
+
+		if !issuer {
+			return cert
+		}
+	}
+
+	return certs[0]
+}
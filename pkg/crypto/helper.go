@@ -1,17 +1,23 @@
 package crypto
 
 import (
+	stdCrypto "crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
 
 	"go.uber.org/zap"
 
 	"k8s.io/api/core/v1"
 )
 
+var errNoPrivateKey = errors.New("no PEM encoded private key found in tls.key")
+
 type SecretWrapper struct {
-	PrivateKey     *rsa.PrivateKey
+	PrivateKey     stdCrypto.Signer
 	CACertificates []*x509.Certificate
 	Certificates   []*x509.Certificate
 }
@@ -19,20 +25,12 @@ type SecretWrapper struct {
 func ParseSecretToCertContainer(secret *v1.Secret) (*SecretWrapper, error) {
 	var err error
 
-	keyBlock, _ := pem.Decode(secret.Data["tls.key"])
-	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-
-	if err != nil {
-		return nil, err
-	}
-
+	key, err := ParsePrivateKey(secret.Data["tls.key"])
 	if err != nil {
 		return nil, err
 	}
 
 	certs := []*x509.Certificate{}
-	caCerts := []*x509.Certificate{}
-
 	raw := secret.Data["tls.crt"]
 
 	for {
@@ -40,29 +38,72 @@ func ParseSecretToCertContainer(secret *v1.Secret) (*SecretWrapper, error) {
 		if block == nil {
 			break
 		}
+		raw = rest
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
 
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err != nil {
 			return nil, err
 		}
 
-		if cert.KeyUsage == 5 {
-			certs = append(certs, cert)
-
-		} else {
-			caCerts = append(caCerts, cert)
-		}
+		certs = append(certs, cert)
+	}
 
-		raw = rest
+	leaf, chain, err := OrderChain(certs, key)
+	if err != nil {
+		return nil, err
 	}
 
 	return &SecretWrapper{
-		Certificates:   certs,
-		CACertificates: caCerts,
+		Certificates:   []*x509.Certificate{leaf},
+		CACertificates: chain,
 		PrivateKey:     key,
 	}, nil
 }
 
+/*
+	Parse the first private key in PEM data, either PKCS#1 RSA, SEC1 EC or a
+	PKCS#8 wrapped RSA or ECDSA key. Other blocks, like the EC PARAMETERS openssl
+	writes before an EC key, are skipped.
+*/
+func ParsePrivateKey(data []byte) (stdCrypto.Signer, error) {
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return nil, errNoPrivateKey
+		}
+		data = rest
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			return parsePKCS8PrivateKey(block.Bytes)
+		}
+	}
+}
+
+func parsePKCS8PrivateKey(der []byte) (stdCrypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PKCS#8 private key type %T", key)
+}
+
 func AdditionalCaCerts(caSecret v1.Secret) (*[]*x509.Certificate, error) {
 	caCerts := []*x509.Certificate{}
 
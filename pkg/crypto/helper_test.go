@@ -0,0 +1,69 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func pemBlock(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func TestParsePrivateKey_should_parse_rsa_keys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParsePrivateKey(pemBlock("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed.(*rsa.PrivateKey).N, key.N)
+
+	parsed, err = ParsePrivateKey(pemBlock("PRIVATE KEY", pkcs8))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed.(*rsa.PrivateKey).N, key.N)
+}
+
+func TestParsePrivateKey_should_parse_ecdsa_keys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* openssl ecparam -genkey writes the curve parameters first */
+	params := pemBlock("EC PARAMETERS", []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07})
+	parsed, err := ParsePrivateKey(append(params, pemBlock("EC PRIVATE KEY", sec1)...))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed.(*ecdsa.PrivateKey).D, key.D)
+
+	parsed, err = ParsePrivateKey(pemBlock("PRIVATE KEY", pkcs8))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, parsed.(*ecdsa.PrivateKey).D, key.D)
+}
+
+func TestParsePrivateKey_should_fail_without_key(t *testing.T) {
+	_, err := ParsePrivateKey([]byte("not a key"))
+	assert.Equal(t, err, errNoPrivateKey)
+
+	_, err = ParsePrivateKey(pemBlock("CERTIFICATE", []byte{}))
+	assert.Equal(t, err, errNoPrivateKey)
+}
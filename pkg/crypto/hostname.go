@@ -0,0 +1,40 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"strings"
+)
+
+func normalizeHostname(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+/*
+	CoversHost - Whether one of the DNS SANs of the certificate matches the host.
+	A wildcard SAN matches a single label, so *.example.com covers a.example.com
+	and the wildcard host *.example.com, but neither example.com nor
+	a.b.example.com. The common name is ignored, like browsers do.
+*/
+func CoversHost(cert *x509.Certificate, host string) bool {
+	host = normalizeHostname(host)
+	for _, name := range cert.DNSNames {
+		name = normalizeHostname(name)
+		if name == host {
+			return true
+		}
+
+		if !strings.HasPrefix(name, "*.") {
+			continue
+		}
+		if dot := strings.Index(host, "."); dot > 0 && host[dot:] == name[1:] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CoversHost - Whether the leaf certificate of the secret covers the host
+func (w *SecretWrapper) CoversHost(host string) bool {
+	return CoversHost(w.Certificates[0], host)
+}
@@ -0,0 +1,22 @@
+package crypto
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestCoversHost(t *testing.T) {
+	cert := &x509.Certificate{DNSNames: []string{"example.com", "*.apps.example.com"}}
+
+	assert.Equal(t, CoversHost(cert, "example.com"), true)
+	assert.Equal(t, CoversHost(cert, "Example.com."), true)
+	assert.Equal(t, CoversHost(cert, "a.apps.example.com"), true)
+	assert.Equal(t, CoversHost(cert, "*.apps.example.com"), true)
+
+	assert.Equal(t, CoversHost(cert, "www.example.com"), false)
+	assert.Equal(t, CoversHost(cert, "apps.example.com"), false)
+	assert.Equal(t, CoversHost(cert, "a.b.apps.example.com"), false)
+	assert.Equal(t, CoversHost(cert, "*.example.com"), false)
+}
@@ -0,0 +1,314 @@
+package director
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+
+	"github.com/evry-bergen/waf-syncer/pkg/crypto"
+)
+
+// ChangeAction - What happens to a managed AG resource
+type ChangeAction string
+
+const (
+	// Added - The resource does not exist on the AG yet
+	Added ChangeAction = "added"
+	// Changed - The resource exists on the AG with different properties
+	Changed ChangeAction = "changed"
+	// Removed - The resource exists on the AG but is no longer wanted
+	Removed ChangeAction = "removed"
+)
+
+// Change - A single managed AG resource that differs between live and desired state
+type Change struct {
+	Action ChangeAction `json:"action"`
+	Name   string       `json:"name"`
+	Source string       `json:"source,omitempty"`
+	Before interface{}  `json:"before,omitempty"`
+	After  interface{}  `json:"after,omitempty"`
+}
+
+// ChangeSet - All differences between the managed part of the live and desired AG
+type ChangeSet struct {
+	Listeners    []Change `json:"listeners"`
+	Certificates []Change `json:"certificates"`
+	Rules        []Change `json:"rules"`
+	Redirects    []Change `json:"redirects"`
+	PathMaps     []Change `json:"urlPathMaps"`
+}
+
+// Set the host or secret each change was made for, as far as the namer knows
+func (c *ChangeSet) describe(names *namer) {
+	for _, list := range [][]Change{c.Listeners, c.Certificates, c.Rules, c.Redirects, c.PathMaps} {
+		for i := range list {
+			list[i].Source = names.source(list[i].Name)
+		}
+	}
+}
+
+// Empty - Whether applying the change set would be a no-op
+func (c *ChangeSet) Empty() bool {
+	return len(c.Listeners) == 0 && len(c.Certificates) == 0 && len(c.Rules) == 0 &&
+		len(c.Redirects) == 0 && len(c.PathMaps) == 0
+}
+
+/*
+	The properties of a listener we manage, with the read-only fields Azure
+	adds (etag, provisioning state, ...) left out so live and desired compare.
+*/
+type listenerSpec struct {
+	HostName                string   `json:"hostName,omitempty"`
+	HostNames               []string `json:"hostNames,omitempty"`
+	Protocol                string   `json:"protocol"`
+	FrontendIPConfiguration string   `json:"frontendIPConfiguration"`
+	FrontendPort            string   `json:"frontendPort"`
+	SslCertificate          string   `json:"sslCertificate,omitempty"`
+}
+
+/*
+	Azure never returns the PFX of a certificate, and re-encoding a PFX never
+	gives the same bytes, so certificates are compared by their leaf.
+*/
+type certificateSpec struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+type ruleSpec struct {
+	RuleType              string `json:"ruleType"`
+	HTTPListener          string `json:"httpListener"`
+	BackendAddressPool    string `json:"backendAddressPool,omitempty"`
+	BackendHTTPSettings   string `json:"backendHttpSettings,omitempty"`
+	RedirectConfiguration string `json:"redirectConfiguration,omitempty"`
+	URLPathMap            string `json:"urlPathMap,omitempty"`
+}
+
+type redirectSpec struct {
+	RedirectType       string `json:"redirectType"`
+	TargetListener     string `json:"targetListener"`
+	IncludePath        bool   `json:"includePath"`
+	IncludeQueryString bool   `json:"includeQueryString"`
+}
+
+type pathRuleSpec struct {
+	Name                string   `json:"name"`
+	Paths               []string `json:"paths"`
+	BackendAddressPool  string   `json:"backendAddressPool"`
+	BackendHTTPSettings string   `json:"backendHttpSettings"`
+}
+
+type urlPathMapSpec struct {
+	DefaultBackendAddressPool  string         `json:"defaultBackendAddressPool"`
+	DefaultBackendHTTPSettings string         `json:"defaultBackendHttpSettings"`
+	PathRules                  []pathRuleSpec `json:"pathRules"`
+}
+
+// managedState - The prefixed sub-resources of an AG, keyed by name
+type managedState struct {
+	Listeners    map[string]listenerSpec
+	Certificates map[string]certificateSpec
+	Rules        map[string]ruleSpec
+	Redirects    map[string]redirectSpec
+	PathMaps     map[string]urlPathMapSpec
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Azure returns an empty list for listeners with a single host name
+func hostNames(names *[]string) []string {
+	if names == nil || len(*names) == 0 {
+		return nil
+	}
+	return *names
+}
+
+/*
+	ARM ids are case insensitive, and Azure does not necessarily return them
+	with the casing we used when creating the resource.
+*/
+func refID(ref *azureNetwork.SubResource) string {
+	if ref == nil {
+		return ""
+	}
+	return strings.ToLower(str(ref.ID))
+}
+
+/*
+	Fingerprint of a certificate as returned by Azure, or empty when it can't be
+	determined, which makes it compare as changed.
+*/
+func liveCertificateFingerprint(cert azureNetwork.ApplicationGatewaySslCertificate) string {
+	if cert.ApplicationGatewaySslCertificatePropertiesFormat == nil || cert.PublicCertData == nil {
+		return ""
+	}
+
+	certs, err := crypto.ParsePublicCertData(*cert.PublicCertData)
+	if err != nil {
+		zap.S().Debugf("Unable to parse public cert data of %s: %s", str(cert.Name), err)
+		return ""
+	}
+
+	return crypto.Fingerprint(crypto.LeafCertificate(certs))
+}
+
+/*
+	Collect the managed resources of an AG. Certificates we are about to upload
+	have no public cert data yet, their fingerprints are passed in instead.
+*/
+func (d *Director) managedState(waf *azureNetwork.ApplicationGateway, fingerprints map[string]string) managedState {
+	state := managedState{
+		Listeners:    map[string]listenerSpec{},
+		Certificates: map[string]certificateSpec{},
+		Rules:        map[string]ruleSpec{},
+		Redirects:    map[string]redirectSpec{},
+		PathMaps:     map[string]urlPathMapSpec{},
+	}
+
+	if waf.HTTPListeners != nil {
+		for _, l := range *waf.HTTPListeners {
+			if !d.hasPrefix(str(l.Name)) || l.ApplicationGatewayHTTPListenerPropertiesFormat == nil {
+				continue
+			}
+			state.Listeners[*l.Name] = listenerSpec{
+				HostName:                str(l.HostName),
+				HostNames:               hostNames(l.HostNames),
+				Protocol:                string(l.Protocol),
+				FrontendIPConfiguration: refID(l.FrontendIPConfiguration),
+				FrontendPort:            refID(l.FrontendPort),
+				SslCertificate:          refID(l.SslCertificate),
+			}
+		}
+	}
+
+	if waf.SslCertificates != nil {
+		for _, c := range *waf.SslCertificates {
+			if !d.hasPrefix(str(c.Name)) {
+				continue
+			}
+			fingerprint, ok := fingerprints[*c.Name]
+			if !ok {
+				fingerprint = liveCertificateFingerprint(c)
+			}
+			state.Certificates[*c.Name] = certificateSpec{Fingerprint: fingerprint}
+		}
+	}
+
+	if waf.RequestRoutingRules != nil {
+		for _, rr := range *waf.RequestRoutingRules {
+			if !d.hasPrefix(str(rr.Name)) || rr.ApplicationGatewayRequestRoutingRulePropertiesFormat == nil {
+				continue
+			}
+			state.Rules[*rr.Name] = ruleSpec{
+				RuleType:              string(rr.RuleType),
+				HTTPListener:          refID(rr.HTTPListener),
+				BackendAddressPool:    refID(rr.BackendAddressPool),
+				BackendHTTPSettings:   refID(rr.BackendHTTPSettings),
+				RedirectConfiguration: refID(rr.RedirectConfiguration),
+				URLPathMap:            refID(rr.URLPathMap),
+			}
+		}
+	}
+
+	if waf.RedirectConfigurations != nil {
+		for _, redirect := range *waf.RedirectConfigurations {
+			props := redirect.ApplicationGatewayRedirectConfigurationPropertiesFormat
+			if !d.hasPrefix(str(redirect.Name)) || props == nil {
+				continue
+			}
+			state.Redirects[*redirect.Name] = redirectSpec{
+				RedirectType:       string(props.RedirectType),
+				TargetListener:     refID(props.TargetListener),
+				IncludePath:        to.Bool(props.IncludePath),
+				IncludeQueryString: to.Bool(props.IncludeQueryString),
+			}
+		}
+	}
+
+	if waf.URLPathMaps != nil {
+		for _, pathMap := range *waf.URLPathMaps {
+			props := pathMap.ApplicationGatewayURLPathMapPropertiesFormat
+			if !d.hasPrefix(str(pathMap.Name)) || props == nil {
+				continue
+			}
+			spec := urlPathMapSpec{
+				DefaultBackendAddressPool:  refID(props.DefaultBackendAddressPool),
+				DefaultBackendHTTPSettings: refID(props.DefaultBackendHTTPSettings),
+				PathRules:                  []pathRuleSpec{},
+			}
+			if props.PathRules != nil {
+				for _, rule := range *props.PathRules {
+					ruleProps := rule.ApplicationGatewayPathRulePropertiesFormat
+					if ruleProps == nil {
+						continue
+					}
+					paths := []string{}
+					if ruleProps.Paths != nil {
+						paths = append(paths, *ruleProps.Paths...)
+					}
+					spec.PathRules = append(spec.PathRules, pathRuleSpec{
+						Name:                str(rule.Name),
+						Paths:               paths,
+						BackendAddressPool:  refID(ruleProps.BackendAddressPool),
+						BackendHTTPSettings: refID(ruleProps.BackendHTTPSettings),
+					})
+				}
+			}
+			state.PathMaps[*pathMap.Name] = spec
+		}
+	}
+
+	return state
+}
+
+/*
+	Compare two maps of specs by name, both maps have to be keyed by string with
+	comparable values.
+*/
+func diffResources(live interface{}, desired interface{}) []Change {
+	changes := []Change{}
+	liveMap := reflect.ValueOf(live)
+	desiredMap := reflect.ValueOf(desired)
+
+	for _, key := range desiredMap.MapKeys() {
+		after := desiredMap.MapIndex(key).Interface()
+		before := liveMap.MapIndex(key)
+
+		if !before.IsValid() {
+			changes = append(changes, Change{Action: Added, Name: key.String(), After: after})
+		} else if !reflect.DeepEqual(before.Interface(), after) {
+			changes = append(changes, Change{Action: Changed, Name: key.String(), Before: before.Interface(), After: after})
+		}
+	}
+
+	for _, key := range liveMap.MapKeys() {
+		if !desiredMap.MapIndex(key).IsValid() {
+			changes = append(changes, Change{Action: Removed, Name: key.String(), Before: liveMap.MapIndex(key).Interface()})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// diff - The change set turning the live managed state into the desired one
+func diff(live managedState, desired managedState) *ChangeSet {
+	return &ChangeSet{
+		Listeners:    diffResources(live.Listeners, desired.Listeners),
+		Certificates: diffResources(live.Certificates, desired.Certificates),
+		Rules:        diffResources(live.Rules, desired.Rules),
+		Redirects:    diffResources(live.Redirects, desired.Redirects),
+		PathMaps:     diffResources(live.PathMaps, desired.PathMaps),
+	}
+}
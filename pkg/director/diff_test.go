@@ -0,0 +1,130 @@
+package director
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/magiconair/properties/assert"
+
+	"github.com/evry-bergen/waf-syncer/pkg/config"
+	"github.com/evry-bergen/waf-syncer/pkg/crypto"
+)
+
+func testCertificate(t *testing.T, host string) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func testPublicCertData(t *testing.T, cert *x509.Certificate) string {
+	data, err := crypto.EncodePublicCertData([]*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func testListener(name string, host string, port string) azureNetwork.ApplicationGatewayHTTPListener {
+	return azureNetwork.ApplicationGatewayHTTPListener{
+		Name: to.StringPtr(name),
+		Etag: to.StringPtr("W/\"etag\""),
+		ApplicationGatewayHTTPListenerPropertiesFormat: &azureNetwork.ApplicationGatewayHTTPListenerPropertiesFormat{
+			HostName:     to.StringPtr(host),
+			Protocol:     azureNetwork.HTTPS,
+			FrontendPort: resourceRef("/subscriptions/s/ag/frontendPorts/" + port),
+		},
+	}
+}
+
+func testWaf(listeners ...azureNetwork.ApplicationGatewayHTTPListener) *azureNetwork.ApplicationGateway {
+	return &azureNetwork.ApplicationGateway{
+		ApplicationGatewayPropertiesFormat: &azureNetwork.ApplicationGatewayPropertiesFormat{
+			HTTPListeners:       &listeners,
+			SslCertificates:     &[]azureNetwork.ApplicationGatewaySslCertificate{},
+			RequestRoutingRules: &[]azureNetwork.ApplicationGatewayRequestRoutingRule{},
+		},
+	}
+}
+
+func TestDiff_should_ignore_unmanaged_and_read_only_fields(t *testing.T) {
+	d := &Director{AzureWafConfig: &config.AzureWafConfig{ListenerPrefix: "wd"}}
+
+	live := testWaf(testListener("wd-a-tls", "a", "HTTPS"), testListener("manual", "m", "https"))
+	desired := testWaf(testListener("wd-a-tls", "a", "https"))
+	(*desired.HTTPListeners)[0].Etag = nil
+
+	changes := diff(d.managedState(live, nil), d.managedState(desired, nil))
+	assert.Equal(t, changes.Empty(), true)
+}
+
+func TestDiff_should_report_added_changed_and_removed(t *testing.T) {
+	d := &Director{AzureWafConfig: &config.AzureWafConfig{ListenerPrefix: "wd"}}
+
+	live := testWaf(testListener("wd-a-tls", "a", "https"), testListener("wd-b-tls", "b", "https"))
+	desired := testWaf(testListener("wd-a-tls", "a", "other"), testListener("wd-c-tls", "c", "https"))
+
+	changes := diff(d.managedState(live, nil), d.managedState(desired, nil))
+	assert.Equal(t, len(changes.Listeners), 3)
+	assert.Equal(t, changes.Listeners[0].Name, "wd-a-tls")
+	assert.Equal(t, changes.Listeners[0].Action, Changed)
+	assert.Equal(t, changes.Listeners[1].Action, Removed)
+	assert.Equal(t, changes.Listeners[2].Action, Added)
+	assert.Equal(t, len(changes.Certificates), 0)
+}
+
+func TestDiff_should_compare_certificates_by_fingerprint(t *testing.T) {
+	d := &Director{AzureWafConfig: &config.AzureWafConfig{ListenerPrefix: "wd"}}
+	cert := testCertificate(t, "a.example.com")
+
+	live := testWaf()
+	live.SslCertificates = &[]azureNetwork.ApplicationGatewaySslCertificate{{
+		Name: to.StringPtr("wd-ns-cert"),
+		ApplicationGatewaySslCertificatePropertiesFormat: &azureNetwork.ApplicationGatewaySslCertificatePropertiesFormat{
+			PublicCertData: to.StringPtr(testPublicCertData(t, cert)),
+		},
+	}}
+	desired := testWaf()
+	desired.SslCertificates = &[]azureNetwork.ApplicationGatewaySslCertificate{{
+		Name: to.StringPtr("wd-ns-cert"),
+		ApplicationGatewaySslCertificatePropertiesFormat: &azureNetwork.ApplicationGatewaySslCertificatePropertiesFormat{
+			Data: to.StringPtr("freshly encoded pfx"),
+		},
+	}}
+
+	same := map[string]string{"wd-ns-cert": crypto.Fingerprint(cert)}
+	assert.Equal(t, diff(d.managedState(live, nil), d.managedState(desired, same)).Empty(), true)
+
+	renewed := map[string]string{"wd-ns-cert": crypto.Fingerprint(testCertificate(t, "a.example.com"))}
+	changes := diff(d.managedState(live, nil), d.managedState(desired, renewed))
+	assert.Equal(t, len(changes.Certificates), 1)
+	assert.Equal(t, changes.Certificates[0].Action, Changed)
+}
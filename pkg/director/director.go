@@ -4,12 +4,16 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
 	"github.com/evry-bergen/waf-syncer/pkg/config"
 	"github.com/evry-bergen/waf-syncer/pkg/crypto"
 
@@ -19,80 +23,168 @@ import (
 
 	istio "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/evry-bergen/waf-syncer/pkg/clients/istio/informers/externalversions/istio/v1alpha3"
 
-	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2018-12-01/network"
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
 	"k8s.io/client-go/kubernetes"
 
 	"go.uber.org/zap"
 	sslMate "software.sslmate.com/src/go-pkcs12"
 )
 
+/*
+	Queued on startup so the AG is reconciled even when there are no Gateways
+	to trigger it, e.g. to prune everything left behind by deleted ones.
+*/
+const resyncKey = "resync"
+
+var errWafUpdating = errors.New("WAF is updating")
+
 // Director - struct for convenience
 type Director struct {
 	AzureWafConfig        *config.AzureWafConfig
-	AzureAGClient         *azureNetwork.ApplicationGatewaysClient
-	ClientSet             *kubernetes.Clientset
-	IstioClient           *istio.Clientset
+	AzureAGClient         azure.ApplicationGatewaysClient
+	ClientSet             kubernetes.Interface
+	IstioClient           istio.Interface
 	GatewayInformer       v1alpha3.GatewayInformer
 	GatewayInformerSynced cache.InformerSynced
 
-	CurrentTargets []TerminationTarget
+	VirtualServiceInformer       v1alpha3.VirtualServiceInformer
+	VirtualServiceInformerSynced cache.InformerSynced
+
+	Targets  *TargetStore
+	Recorder record.EventRecorder
+
+	queue   workqueue.RateLimitingInterface
+	secrets *secretWatcher
+
+	lastChangesLock sync.RWMutex
+	lastChanges     *ChangeSet
+
+	// Unix nanoseconds the running reconcile started at, 0 when idle
+	reconcileStarted int64
+	readiness        readiness
 }
 
 // Run - run it
 func (d *Director) Run(stop <-chan struct{}) {
 	zap.S().Info("Starting application synchronization")
 
-	if !cache.WaitForCacheSync(stop, d.GatewayInformerSynced) {
+	if !cache.WaitForCacheSync(stop, d.GatewayInformerSynced, d.VirtualServiceInformerSynced) {
 		zap.S().Error("timed out waiting for cache sync")
 		return
 	}
 
+	recordLeader(true)
+	go func() {
+		<-stop
+		recordLeader(false)
+		d.queue.ShutDown()
+	}()
+
+	d.secrets.start(stop)
+	d.queue.Add(resyncKey)
 	go d.syncWAFLoop(stop)
 }
 
-func (d *Director) syncRetryDelay() {
-	time.Sleep(time.Second * 5)
+func (d *Director) enqueue(key string) {
+	d.queue.Add(key)
 }
 
-func (d *Director) add(gw interface{}) {
-	// zap.S().Infof("Add: %s", gw)
-	d.update(nil, gw)
+func (d *Director) enqueueGateway(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+
+	d.enqueue(key)
 }
 
-func (d *Director) update(old interface{}, new interface{}) {
-	var gw, previous *istioApiv1alpha3.Gateway
+/*
+	Enqueue every Gateway with a target using the given secret
+*/
+func (d *Director) enqueueSecret(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
 
-	if old != nil {
-		previous = old.(*istioApiv1alpha3.Gateway)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		zap.S().Error(err)
+		return
 	}
 
-	if previous != nil {
-		// fmt.Printf("%s", previous)
+	for _, target := range d.Targets.Snapshot() {
+		if target.Namespace == namespace && target.Secret == name {
+			zap.S().Debugf("Secret %s changed, resyncing gateway %s/%s", key, target.Namespace, target.Gateway)
+			d.enqueue(target.gatewayKey())
+		}
 	}
+}
+
+func (d *Director) add(gw interface{}) {
+	// zap.S().Infof("Add: %s", gw)
+	d.update(nil, gw)
+}
 
-	gw = new.(*istioApiv1alpha3.Gateway)
+func (d *Director) update(old interface{}, new interface{}) {
+	gw := new.(*istioApiv1alpha3.Gateway)
 
-	for _, srv := range gw.Spec.Servers {
-		if srv.TLS != nil {
+	targets := make([]TerminationTarget, 0)
+	for i, srv := range gw.Spec.Servers {
+		if terminatesTLS(srv) {
 			zap.S().Info("Found TLS enabled port")
 			secretName := srv.TLS.CredentialName
 
 			target := TerminationTarget{
-				Hosts:     srv.Hosts,
-				Secret:    secretName,
-				Target:    d.AzureWafConfig.BackendPool,
-				Namespace: gw.Namespace,
+				Hosts:               srv.Hosts,
+				Secret:              secretName,
+				Target:              annotationOr(gw.Annotations, AnnotationBackendPool, d.AzureWafConfig.BackendPool),
+				BackendHttpSettings: annotationOr(gw.Annotations, AnnotationHTTPSettings, d.AzureWafConfig.BackendHttpSettings),
+				FrontendPort:        annotationOr(gw.Annotations, AnnotationFrontendPort, d.AzureWafConfig.FrontendPort),
+				HTTPFrontendPort:    annotationOr(gw.Annotations, AnnotationHTTPFrontendPort, d.AzureWafConfig.HTTPFrontendPort),
+				HTTPSRedirect:       httpsRedirect(gw),
+				MultiHostListener:   multiHostListener(gw, d.AzureWafConfig.MultiHostListeners),
+				Namespace:           gw.Namespace,
+				Gateway:             gw.Name,
+				Generation:          gw.Generation,
+				Server:              i,
 			}
 
 			zap.S().Debugf("Adding for %s for configuration with secret %s", target.Hosts, secretName)
-			d.CurrentTargets = append(d.CurrentTargets, target)
+			targets = append(targets, target)
+		}
+	}
+
+	/* Replace whatever this Gateway contributed earlier, servers may have been removed */
+	d.Targets.Replace(gw.Namespace, gw.Name, targets)
+}
+
+func (d *Director) delete(obj interface{}) {
+	gw, ok := obj.(*istioApiv1alpha3.Gateway)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			zap.S().Errorf("Couldn't get object from tombstone %#v", obj)
+			return
+		}
+
+		gw, ok = tombstone.Obj.(*istioApiv1alpha3.Gateway)
+		if !ok {
+			zap.S().Errorf("Tombstone contained object that is not a Gateway %#v", tombstone.Obj)
+			return
 		}
 	}
+
+	zap.S().Debugf("Removing targets for deleted gateway %s/%s", gw.Namespace, gw.Name)
+	d.Targets.Delete(gw.Namespace, gw.Name)
 }
 
 func resourceRef(id string) *azureNetwork.SubResource {
@@ -109,8 +201,42 @@ func contains(data []string, element string) bool {
 	return false
 }
 
-func (d *Director) syncTargetsToWAF(waf *azureNetwork.ApplicationGateway) {
+// syncResult - Information about the synced resources that is not part of the AG document
+type syncResult struct {
+	// Leaf fingerprint of each certificate, by certificate name
+	CertificateFingerprints map[string]string
+	// Leaf expiry of each certificate, by certificate name
+	CertificateExpiry map[string]time.Time
+	// Number of targets left out because of secret errors
+	SkippedTargets int
+	// Target each managed resource was created for, by resource name
+	Owners map[string]TerminationTarget
+	// Why targets or hosts were left out, by Gateway key
+	Errors map[string][]string
+	// Targets the sync was computed from
+	Targets []TerminationTarget
+	// Targets whose certificate is synced
+	Synced []TerminationTarget
+	// Name of the certificate synced for each secret, by namespace/secret
+	Certificates map[string]string
+	// Names of the managed resources and what they were created for
+	Names *namer
+}
+
+func newSyncResult() *syncResult {
+	return &syncResult{
+		CertificateFingerprints: map[string]string{},
+		CertificateExpiry:       map[string]time.Time{},
+		Owners:                  map[string]TerminationTarget{},
+		Errors:                  map[string][]string{},
+		Certificates:            map[string]string{},
+		Names:                   newNamer(),
+	}
+}
+
+func (d *Director) syncTargetsToWAF(waf *azureNetwork.ApplicationGateway) *syncResult {
 	wdPrefix := d.AzureWafConfig.ListenerPrefix
+	result := newSyncResult()
 	listenersByName := map[string]azureNetwork.ApplicationGatewayHTTPListener{}
 
 	/*
@@ -120,6 +246,8 @@ func (d *Director) syncTargetsToWAF(waf *azureNetwork.ApplicationGateway) {
 	agCertificates := d.certificatesToSync(waf)
 	agListeners := d.listenersToSync(waf, listenersByName)
 	agRoutingRules := d.rulesToSync(waf)
+	agRedirects := d.redirectsToSync(waf)
+	agPathMaps := d.urlPathMapsToSync(waf)
 
 	/*
 		We are looking at the current Targets aka VirtualGateways and their secrets, from this
@@ -129,74 +257,211 @@ func (d *Director) syncTargetsToWAF(waf *azureNetwork.ApplicationGateway) {
 		Tracking already added listeners & certificates as AG will fail if you add duplicates.
 	*/
 	addedListeners := make([]string, 0)
-	addedCerts := make([]string, 0)
-	for _, target := range d.CurrentTargets {
+	hostOwners := map[string]TerminationTarget{}
+	invalidSecrets := map[string]error{}
+	wrappers := map[string]*crypto.SecretWrapper{}
+	certificates := map[string]azureNetwork.ApplicationGatewaySslCertificate{}
+	certificateOrder := make([]string, 0)
+	certificateRefs := map[string]int{}
+	result.Targets = d.Targets.Snapshot()
+	d.secrets.sync(result.Targets)
+	for _, target := range result.Targets {
 		rules := make([]azureNetwork.ApplicationGatewayRequestRoutingRule, 0)
 		listeners := make([]azureNetwork.ApplicationGatewayHTTPListener, 0)
-
-		/* Avoid duplicate secrets */
-		secretName := target.generateSecretName(wdPrefix)
-		if contains(addedCerts, secretName) {
-			zap.S().Debugf("Skipping duplicate certificate %s", secretName)
+		redirects := make([]azureNetwork.ApplicationGatewayRedirectConfiguration, 0)
+		pathMaps := make([]azureNetwork.ApplicationGatewayURLPathMap, 0)
+
+		/*
+			Certificates are named by their content, so the same certificate in
+			several secrets, e.g. a wildcard copied to every namespace, is a single
+			AG certificate. A target whose secret can't be used is held back
+			entirely, AG rejects listeners referencing a missing certificate.
+		*/
+		secretKey := target.secretKey()
+		if err, invalid := invalidSecrets[secretKey]; invalid {
+			d.warnInvalidSecret(target, err)
+			result.skip(target, err)
 			continue
 		}
-		addedCerts = append(addedCerts, secretName)
+		certName, parsed := result.Certificates[secretKey]
+		if !parsed {
+			wrapper, err := d.targetSecret(target)
+			if err == nil {
+				certName = result.Names.name(secretKey, wdPrefix, "cert", wrapper.ContentHash()[:16])
+				if _, added := certificates[certName]; !added {
+					var agCert *azureNetwork.ApplicationGatewaySslCertificate
+					agCert, err = d.convertCertificateToAGCertificate(certName, wrapper)
+					if err == nil {
+						certificates[certName] = *agCert
+						certificateOrder = append(certificateOrder, certName)
+						wrappers[certName] = wrapper
+						result.CertificateFingerprints[certName] = wrapper.Fingerprint()
+						result.CertificateExpiry[certName] = wrapper.Certificates[0].NotAfter
+						result.Owners[certName] = target
+					}
+				}
+			}
+			if err != nil {
+				invalidSecrets[secretKey] = err
+				d.warnInvalidSecret(target, err)
+				result.skip(target, err)
+				continue
+			}
+			result.Certificates[secretKey] = certName
+		}
 
+		/*
+			Hosts the certificate doesn't cover or another Gateway already synced
+			are left out, AG would reject or misroute them.
+		*/
+		hosts := make([]string, 0)
 		for _, host := range target.Hosts {
-			listener := d.targetListener(target, wdPrefix, waf, host)
-			zap.S().Debugf("Syncing host:%s, listener:%s secret:%s", host, *listener.Name, target.Secret)
-
-			if contains(addedListeners, *listener.Name) {
-				zap.S().Debugf("Skipping duplicate listener %s", listener.Name)
+			if host != catchAllHost && !wrappers[certName].CoversHost(host) {
+				d.warnHostNotCovered(target, host)
+				result.addError(target, "host %s is not covered by the certificate of secret %s", host, target.Secret)
 				continue
 			}
+			if owner, taken := hostOwners[strings.ToLower(host)]; taken {
+				d.warnHostConflict(target, host, owner)
+				result.addError(target, "host %s is already synced from gateway %s", host, owner.gatewayKey())
+				continue
+			}
+			hostOwners[strings.ToLower(host)] = target
+			hosts = append(hosts, host)
+		}
+
+		synced := false
+		shared := 0
+		for _, group := range d.listenerGroups(waf, target, hosts) {
+			index := shared
+			if len(group) > 1 {
+				shared++
+			}
+
+			/* Shared listeners are named by their server, the hosts can change */
+			source := strings.Join(group, ", ")
+			name := func(protocol string) string {
+				if len(group) == 1 {
+					return result.Names.name(source, wdPrefix, group[0], protocol)
+				}
+				return result.Names.name(source, wdPrefix, target.Namespace, target.Gateway,
+					strconv.Itoa(target.Server), strconv.Itoa(index), protocol)
+			}
+
+			listener := d.targetListener(target, name("tls"), waf, group, certName)
+			zap.S().Debugf("Syncing hosts:%v, listener:%s secret:%s", group, *listener.Name, target.Secret)
+
 			addedListeners = append(addedListeners, *listener.Name)
+			result.Owners[*listener.Name] = target
+			certificateRefs[certName]++
+			synced = true
 
 			routingRule := d.targetRoutingRules(waf, listener, target)
+			if pathMap := d.targetURLPathMap(waf, *listener.Name, target, group[0]); pathMap != nil {
+				routingRule = d.targetPathBasedRoutingRule(waf, listener, *pathMap)
+				pathMaps = append(pathMaps, *pathMap)
+			}
 
 			rules = append(rules, routingRule)
 			listeners = append(listeners, listener)
+
+			if target.HTTPSRedirect {
+				httpListener := d.targetHTTPListener(target, name("http"), waf, group)
+				if contains(addedListeners, *httpListener.Name) {
+					zap.S().Debugf("Skipping duplicate listener %s", *httpListener.Name)
+					continue
+				}
+				addedListeners = append(addedListeners, *httpListener.Name)
+				result.Owners[*httpListener.Name] = target
+
+				redirect := d.targetRedirect(waf, httpListener, listener)
+				rules = append(rules, d.targetRedirectRoutingRule(waf, httpListener, redirect))
+				listeners = append(listeners, httpListener)
+				redirects = append(redirects, redirect)
+			}
 		}
 
-		secret, err := d.getSecretForTarget(target)
-		if err != nil {
-			zap.S().Infof("Error getting secret for listener %s, not added to listener list", target.Secret)
-			zap.S().Error(err)
-			continue
+		/* Targets without any listener don't use their certificate, it is pruned */
+		if synced {
+			d.checkExpiry(target, result.CertificateExpiry[certName])
+			result.Synced = append(result.Synced, target)
 		}
 
-		agCert, _ := d.convertCertificateToAGCertificate(target.generateSecretName(wdPrefix), secret)
-		agCertificates = append(agCertificates, *agCert)
 		agListeners = append(agListeners, listeners...)
 		agRoutingRules = append(agRoutingRules, rules...)
+		agRedirects = append(agRedirects, redirects...)
+		agPathMaps = append(agPathMaps, pathMaps...)
+	}
+
+	/*
+		Only certificates still referenced by a listener are kept, the others are
+		pruned along with the listeners that used them.
+	*/
+	for _, name := range certificateOrder {
+		if certificateRefs[name] > 0 {
+			agCertificates = append(agCertificates, certificates[name])
+			continue
+		}
+		delete(result.CertificateFingerprints, name)
+		delete(result.CertificateExpiry, name)
+		delete(result.Owners, name)
 	}
 
 	waf.HTTPListeners = &agListeners
 	waf.SslCertificates = &agCertificates
 	waf.RequestRoutingRules = &agRoutingRules
+	waf.RedirectConfigurations = &agRedirects
+	waf.URLPathMaps = &agPathMaps
 
 	zap.S().Debugf("Have %d certificatesToSync", len(*waf.SslCertificates))
+	return result
+}
+
+func (r *syncResult) skip(target TerminationTarget, err error) {
+	r.SkippedTargets++
+	r.addError(target, "secret %s: %s", target.Secret, err)
+}
+
+func (r *syncResult) addError(target TerminationTarget, format string, args ...interface{}) {
+	key := target.gatewayKey()
+	r.Errors[key] = append(r.Errors[key], fmt.Sprintf(format, args...))
 }
 
 /*
-	Convert a Secret to PFX
+	The parsed secret of a target
 */
-func (d *Director) convertSecretCertToPfx(secret *v1.Secret) ([]byte, error) {
+func (d *Director) targetSecret(target TerminationTarget) (*crypto.SecretWrapper, error) {
+	secret, err := d.getSecretForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
 	wrapper, err := crypto.ParseSecretToCertContainer(secret)
 	if err != nil {
-		zap.S().Error(err)
 		return nil, err
 	}
 
+	notAfter := wrapper.Certificates[0].NotAfter
+	if d.AzureWafConfig.RejectExpiredCerts && time.Now().After(notAfter) {
+		return nil, &expiredError{NotAfter: notAfter}
+	}
+
+	return wrapper, nil
+}
+
+/*
+	Convert the certificate of a Secret to PFX
+*/
+func (d *Director) convertSecretCertToPfx(wrapper *crypto.SecretWrapper) ([]byte, error) {
 	return sslMate.Encode(rand.Reader, wrapper.PrivateKey, wrapper.Certificates[0], wrapper.CACertificates, "azure")
 }
 
 /*
 	Convert Certificate inside a Secret to AG Certificate object
 */
-func (d *Director) convertCertificateToAGCertificate(secretName string, secret *v1.Secret) (*azureNetwork.ApplicationGatewaySslCertificate, error) {
+func (d *Director) convertCertificateToAGCertificate(secretName string, wrapper *crypto.SecretWrapper) (*azureNetwork.ApplicationGatewaySslCertificate, error) {
 	zap.S().Debugf("Converting certificate %s", secretName)
-	certPfx, err := d.convertSecretCertToPfx(secret)
+	certPfx, err := d.convertSecretCertToPfx(wrapper)
 	if err != nil {
 		return nil, err
 	}
@@ -215,11 +480,11 @@ func (d *Director) convertCertificateToAGCertificate(secretName string, secret *
 }
 
 /*
-	Fetch the given secret
+	Fetch the given secret from its watch, or from the API until that has
+	synced. Rotations are picked up by enqueueSecret
 */
 func (d *Director) getSecretForTarget(target TerminationTarget) (*v1.Secret, error) {
-	secret, err := d.ClientSet.CoreV1().Secrets(target.Namespace).Get(target.Secret, metav1.GetOptions{})
-	return secret, err
+	return d.secrets.get(target.Namespace, target.Secret)
 }
 
 func (d *Director) targetRoutingRules(waf *azureNetwork.ApplicationGateway, listener azureNetwork.ApplicationGatewayHTTPListener, target TerminationTarget) azureNetwork.ApplicationGatewayRequestRoutingRule {
@@ -231,30 +496,103 @@ func (d *Director) targetRoutingRules(waf *azureNetwork.ApplicationGateway, list
 			RuleType:            azureNetwork.Basic,
 			HTTPListener:        &httpListenerSubResource,
 			BackendAddressPool:  resourceRef(fmt.Sprintf("%s/backendAddressPools/%s", *waf.ID, target.Target)),
-			BackendHTTPSettings: resourceRef(fmt.Sprintf("%s/backendHttpSettingsCollection/%s", *waf.ID, d.AzureWafConfig.BackendHttpSettings)),
+			BackendHTTPSettings: resourceRef(fmt.Sprintf("%s/backendHttpSettingsCollection/%s", *waf.ID, target.BackendHttpSettings)),
 		},
 	}
 
 	return routingRule
 }
 
-func (d *Director) targetListener(target TerminationTarget, wdPrefix string, waf *azureNetwork.ApplicationGateway, host string) azureNetwork.ApplicationGatewayHTTPListener {
+/*
+	Hosts of a target sharing a listener. A listener has a single routing rule,
+	so the catch-all host and hosts with path based routing always get a
+	listener of their own, as does every host without MultiHostListener. The
+	others share listeners of at most maxListenerHostNames host names.
+*/
+func (d *Director) listenerGroups(waf *azureNetwork.ApplicationGateway, target TerminationTarget, hosts []string) [][]string {
+	groups := make([][]string, 0)
+	shared := make([]string, 0)
+	for _, host := range hosts {
+		if !target.MultiHostListener || host == catchAllHost || d.targetURLPathMap(waf, "", target, host) != nil {
+			groups = append(groups, []string{host})
+			continue
+		}
+		shared = append(shared, host)
+	}
+
+	for len(shared) > maxListenerHostNames {
+		groups = append(groups, shared[:maxListenerHostNames])
+		shared = shared[maxListenerHostNames:]
+	}
+	if len(shared) > 0 {
+		groups = append(groups, shared)
+	}
+
+	return groups
+}
+
+func (d *Director) targetListener(target TerminationTarget, name string, waf *azureNetwork.ApplicationGateway, hosts []string, certName string) azureNetwork.ApplicationGatewayHTTPListener {
 	listener := azureNetwork.ApplicationGatewayHTTPListener{}
-	listenerName := fmt.Sprintf("%s-tls", target.generateNameWithPrefix(wdPrefix, host))
-	listener.Name = &listenerName
+	listener.Name = to.StringPtr(name)
+	hostName, hostNames := listenerHosts(hosts)
 
 	frontendIPRef := resourceRef(*(*waf.FrontendIPConfigurations)[0].ID)
 	listener.ApplicationGatewayHTTPListenerPropertiesFormat = &azureNetwork.ApplicationGatewayHTTPListenerPropertiesFormat{
 		FrontendIPConfiguration: frontendIPRef,
-		FrontendPort:            resourceRef(fmt.Sprintf("%s/frontEndPorts/%s", *waf.ID, d.AzureWafConfig.FrontendPort)),
-		HostName:                to.StringPtr(host),
+		FrontendPort:            resourceRef(fmt.Sprintf("%s/frontEndPorts/%s", *waf.ID, target.FrontendPort)),
+		HostName:                hostName,
+		HostNames:               hostNames,
 		Protocol:                azureNetwork.HTTPS,
-		SslCertificate:          resourceRef(fmt.Sprintf("%s/sslCertificates/%s", *waf.ID, target.generateSecretName(wdPrefix))),
+		SslCertificate:          resourceRef(fmt.Sprintf("%s/sslCertificates/%s", *waf.ID, certName)),
+	}
+
+	return listener
+}
+
+/*
+	HTTP listener on the plain HTTP frontend port, redirected to the TLS listener
+	of the same hosts
+*/
+func (d *Director) targetHTTPListener(target TerminationTarget, name string, waf *azureNetwork.ApplicationGateway, hosts []string) azureNetwork.ApplicationGatewayHTTPListener {
+	listener := azureNetwork.ApplicationGatewayHTTPListener{}
+	listener.Name = to.StringPtr(name)
+	hostName, hostNames := listenerHosts(hosts)
+
+	frontendIPRef := resourceRef(*(*waf.FrontendIPConfigurations)[0].ID)
+	listener.ApplicationGatewayHTTPListenerPropertiesFormat = &azureNetwork.ApplicationGatewayHTTPListenerPropertiesFormat{
+		FrontendIPConfiguration: frontendIPRef,
+		FrontendPort:            resourceRef(fmt.Sprintf("%s/frontEndPorts/%s", *waf.ID, target.HTTPFrontendPort)),
+		HostName:                hostName,
+		HostNames:               hostNames,
+		Protocol:                azureNetwork.HTTP,
 	}
 
 	return listener
 }
 
+func (d *Director) targetRedirect(waf *azureNetwork.ApplicationGateway, httpListener azureNetwork.ApplicationGatewayHTTPListener, tlsListener azureNetwork.ApplicationGatewayHTTPListener) azureNetwork.ApplicationGatewayRedirectConfiguration {
+	return azureNetwork.ApplicationGatewayRedirectConfiguration{
+		Name: to.StringPtr(*httpListener.Name),
+		ApplicationGatewayRedirectConfigurationPropertiesFormat: &azureNetwork.ApplicationGatewayRedirectConfigurationPropertiesFormat{
+			RedirectType:       azureNetwork.Permanent,
+			TargetListener:     resourceRef(fmt.Sprintf("%s/httpListeners/%s", *waf.ID, *tlsListener.Name)),
+			IncludePath:        to.BoolPtr(true),
+			IncludeQueryString: to.BoolPtr(true),
+		},
+	}
+}
+
+func (d *Director) targetRedirectRoutingRule(waf *azureNetwork.ApplicationGateway, httpListener azureNetwork.ApplicationGatewayHTTPListener, redirect azureNetwork.ApplicationGatewayRedirectConfiguration) azureNetwork.ApplicationGatewayRequestRoutingRule {
+	return azureNetwork.ApplicationGatewayRequestRoutingRule{
+		Name: to.StringPtr(*httpListener.Name),
+		ApplicationGatewayRequestRoutingRulePropertiesFormat: &azureNetwork.ApplicationGatewayRequestRoutingRulePropertiesFormat{
+			RuleType:              azureNetwork.Basic,
+			HTTPListener:          resourceRef(fmt.Sprintf("%s/httpListeners/%s", *waf.ID, *httpListener.Name)),
+			RedirectConfiguration: resourceRef(fmt.Sprintf("%s/redirectConfigurations/%s", *waf.ID, *redirect.Name)),
+		},
+	}
+}
+
 func (d *Director) rulesToSync(waf *azureNetwork.ApplicationGateway) []azureNetwork.ApplicationGatewayRequestRoutingRule {
 	routingRules := []azureNetwork.ApplicationGatewayRequestRoutingRule{}
 
@@ -283,6 +621,23 @@ func (d *Director) listenersToSync(waf *azureNetwork.ApplicationGateway, listene
 	return listeners
 }
 
+func (d *Director) redirectsToSync(waf *azureNetwork.ApplicationGateway) []azureNetwork.ApplicationGatewayRedirectConfiguration {
+	redirects := []azureNetwork.ApplicationGatewayRedirectConfiguration{}
+	if waf.RedirectConfigurations == nil {
+		return redirects
+	}
+
+	for _, redirect := range *waf.RedirectConfigurations {
+		if !d.hasPrefix(*redirect.Name) {
+			redirects = append(redirects, redirect)
+		} else {
+			zap.S().Debugf("Skipping redirect configuration %s", *redirect.Name)
+		}
+	}
+
+	return redirects
+}
+
 func (d *Director) certificatesToSync(waf *azureNetwork.ApplicationGateway) []azureNetwork.ApplicationGatewaySslCertificate {
 	sslCertificates := []azureNetwork.ApplicationGatewaySslCertificate{}
 	for _, sslCert := range *waf.SslCertificates {
@@ -302,69 +657,219 @@ func (d *Director) hasPrefix(name string) bool {
 }
 
 func (d *Director) syncWAFLoop(stop <-chan struct{}) {
-	agName := d.AzureWafConfig.Name
-	agRgName := d.AzureWafConfig.ResourceGroup
+	for d.processNextBatch(stop) {
+	}
+}
+
+/*
+	Wait for a change, give related changes the debounce period to arrive and
+	reconcile all of them with a single AG update.
+*/
+func (d *Director) processNextBatch(stop <-chan struct{}) bool {
+	key, shutdown := d.queue.Get()
+	if shutdown {
+		return false
+	}
+	keys := []interface{}{key}
+
+	select {
+	case <-stop:
+		// Stopped leading or shutting down, the next leader reconciles instead
+		d.queue.Done(key)
+		return false
+	case <-time.After(d.AzureWafConfig.SyncDebounce):
+	}
 
-	var (
-		updateFuture azureNetwork.ApplicationGatewaysCreateOrUpdateFuture
-	)
+	for d.queue.Len() > 0 {
+		key, shutdown := d.queue.Get()
+		if shutdown {
+			break
+		}
+		keys = append(keys, key)
+	}
 
-	for {
-		waf, err := d.AzureAGClient.Get(context.Background(), agRgName, agName)
+	zap.S().Debugf("Reconciling WAF for %v", keys)
+	err := d.reconcile()
+	if err != nil {
+		zap.S().Error(err)
+	}
 
+	for _, key := range keys {
 		if err != nil {
-			zap.S().Error(err)
-			zap.S().Infof("Error getting WAF %s %s", agRgName, agName)
-			d.syncRetryDelay()
-			continue
+			d.queue.AddRateLimited(key)
+		} else {
+			d.queue.Forget(key)
 		}
+		d.queue.Done(key)
+	}
 
-		if *waf.ProvisioningState == "Updating" {
-			zap.S().Debugf("WAF is updating, sleeping.")
-			d.syncRetryDelay()
-			continue
-		}
+	return true
+}
 
-		d.syncTargetsToWAF(&waf)
-		zap.S().Info("Updating WAF")
+func (d *Director) setLastChanges(changes *ChangeSet) {
+	d.lastChangesLock.Lock()
+	defer d.lastChangesLock.Unlock()
 
-		updateFuture, err = d.AzureAGClient.CreateOrUpdate(context.Background(), agRgName, agName, waf)
-		if err != nil {
-			zap.S().Error(err)
-		}
+	d.lastChanges = changes
+	recordChangeSet(changes)
+}
 
-		err = updateFuture.WaitForCompletionRef(context.Background(), d.AzureAGClient.Client)
-		if err != nil {
-			zap.S().Error(err)
+// LastChanges - The change set computed by the last reconcile, nil before the first one
+func (d *Director) LastChanges() *ChangeSet {
+	d.lastChangesLock.RLock()
+	defer d.lastChangesLock.RUnlock()
+
+	return d.lastChanges
+}
+
+func (d *Director) logChanges(changes *ChangeSet) {
+	for _, change := range changes.Listeners {
+		zap.S().Infof("WAF listener %s %s", change.Name, change.Action)
+	}
+	for _, change := range changes.Certificates {
+		zap.S().Infof("WAF certificate %s %s", change.Name, change.Action)
+	}
+	for _, change := range changes.Rules {
+		zap.S().Infof("WAF rule %s %s", change.Name, change.Action)
+	}
+	for _, change := range changes.Redirects {
+		zap.S().Infof("WAF redirect configuration %s %s", change.Name, change.Action)
+	}
+	for _, change := range changes.PathMaps {
+		zap.S().Infof("WAF url path map %s %s", change.Name, change.Action)
+	}
+}
+
+/*
+	Apply the current targets to the AG document and return how that changed
+	the managed resources, along with the managed resources it now has
+*/
+func (d *Director) desiredChanges(waf *azureNetwork.ApplicationGateway) (*ChangeSet, managedState, *syncResult) {
+	live := d.managedState(waf, nil)
+	result := d.syncTargetsToWAF(waf)
+	skippedTargets.Set(float64(result.SkippedTargets))
+	d.recordCertificateExpiry(result)
+
+	desired := d.managedState(waf, result.CertificateFingerprints)
+	changes := diff(live, desired)
+	changes.describe(result.Names)
+	return changes, desired, result
+}
+
+/*
+	Bring the AG in line with the current targets, only updating it when the
+	managed resources actually differ.
+*/
+func (d *Director) reconcile() (err error) {
+	syncAttempts.Inc()
+	d.startReconcile()
+	defer func() {
+		d.finishReconcile()
+		recordSyncResult(err)
+	}()
+
+	agName := d.AzureWafConfig.Name
+	agRgName := d.AzureWafConfig.ResourceGroup
+
+	waf, err := d.AzureAGClient.Get(context.Background(), agRgName, agName)
+	if err != nil {
+		zap.S().Infof("Error getting WAF %s %s", agRgName, agName)
+		return err
+	}
+
+	recordProvisioningState(string(waf.ProvisioningState))
+	if waf.ProvisioningState == azureNetwork.Updating {
+		zap.S().Debugf("WAF is updating, retrying later.")
+		return errWafUpdating
+	}
+
+	changes, desired, result := d.desiredChanges(&waf)
+	d.setLastChanges(changes)
+	if changes.Empty() {
+		zap.S().Debug("WAF is up to date")
+		recordManagedResources(desired)
+		if !d.AzureWafConfig.DryRun {
+			d.writeStatus(changes, result, nil)
 		}
+		return nil
+	}
+	d.logChanges(changes)
 
-		zap.S().Info("Successfully updated WAF")
+	if d.AzureWafConfig.DryRun {
+		zap.S().Infow("Dry run, not updating WAF", "changes", changes)
+		return nil
+	}
 
-		time.Sleep(30 * time.Second)
+	zap.S().Info("Updating WAF")
+	start := time.Now()
+	updateFuture, err := d.AzureAGClient.CreateOrUpdate(context.Background(), agRgName, agName, waf)
+	if err == nil {
+		err = updateFuture.WaitForCompletion(context.Background())
+		updateDuration.Observe(time.Since(start).Seconds())
 	}
+	d.reportUpdate(changes, result.Owners, err)
+	d.writeStatus(changes, result, err)
+	if err != nil {
+		return err
+	}
+
+	zap.S().Info("Successfully updated WAF")
+	recordManagedResources(desired)
+	return nil
 }
 
 // NewDirector - Creates a new instance of the director
 func NewDirector(
-	k8sClient *kubernetes.Clientset, istioClient *istio.Clientset, agClient *azureNetwork.ApplicationGatewaysClient, gwInformer v1alpha3.GatewayInformer) *Director {
+	k8sClient kubernetes.Interface, istioClient istio.Interface, agClient azure.ApplicationGatewaysClient,
+	gwInformer v1alpha3.GatewayInformer, vsInformer v1alpha3.VirtualServiceInformer) *Director {
 	azureConfig := config.NewAzureConfig()
 	director := &Director{
-		AzureWafConfig:        azureConfig,
-		AzureAGClient:         agClient,
-		ClientSet:             k8sClient,
-		IstioClient:           istioClient,
-		GatewayInformer:       gwInformer,
-		GatewayInformerSynced: gwInformer.Informer().HasSynced,
-		CurrentTargets:        make([]TerminationTarget, 0),
+		AzureWafConfig:               azureConfig,
+		AzureAGClient:                agClient,
+		ClientSet:                    k8sClient,
+		IstioClient:                  istioClient,
+		GatewayInformer:              gwInformer,
+		GatewayInformerSynced:        gwInformer.Informer().HasSynced,
+		VirtualServiceInformer:       vsInformer,
+		VirtualServiceInformerSynced: vsInformer.Informer().HasSynced,
+		Targets:                      NewTargetStore(),
+		Recorder:                     newEventRecorder(k8sClient),
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, 5*time.Minute), "waf-syncer"),
 	}
 
 	gwInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(newPod interface{}) {
 				director.add(newPod)
+				director.enqueueGateway(newPod)
 			},
 			UpdateFunc: func(oldGw, newGw interface{}) {
+				if statusOnlyUpdate(oldGw, newGw) {
+					return
+				}
 				director.update(oldGw, newGw)
+				director.enqueueGateway(newGw)
+			},
+			DeleteFunc: func(gw interface{}) {
+				director.delete(gw)
+				director.enqueueGateway(gw)
+			},
+		})
+
+	director.secrets = newSecretWatcher(k8sClient, director.enqueueSecret)
+
+	vsInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(vs interface{}) {
+				director.enqueueVirtualService(vs)
+			},
+			UpdateFunc: func(oldVs, newVs interface{}) {
+				director.enqueueVirtualService(oldVs)
+				director.enqueueVirtualService(newVs)
+			},
+			DeleteFunc: func(vs interface{}) {
+				director.enqueueVirtualService(vs)
 			},
 		})
 
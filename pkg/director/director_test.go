@@ -3,7 +3,12 @@ package director
 import (
 	"testing"
 
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
 	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 
 	"github.com/evry-bergen/waf-syncer/pkg/config"
 )
@@ -33,3 +38,68 @@ func TestDirector_HasPrefix_should_succeed(t *testing.T) {
 	result := d.hasPrefix(input)
 	assert.Equal(t, true, result, "string has correct prefix")
 }
+
+func testGateway(namespace string, name string, servers ...istioApiv1alpha3.Server) *istioApiv1alpha3.Gateway {
+	return &istioApiv1alpha3.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       istioApiv1alpha3.GatewaySpec{Servers: servers},
+	}
+}
+
+func testTLSServer(secret string, hosts ...string) istioApiv1alpha3.Server {
+	return istioApiv1alpha3.Server{
+		Hosts: hosts,
+		TLS:   &istioApiv1alpha3.TLSOptions{CredentialName: secret},
+	}
+}
+
+func testDirector() *Director {
+	return &Director{
+		AzureWafConfig: &config.AzureWafConfig{ListenerPrefix: "wd", BackendPool: "pool"},
+		Targets:        NewTargetStore(),
+		queue:          workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+func TestDirector_Update_should_replace_previous_targets(t *testing.T) {
+	d := testDirector()
+	gw := testGateway("ns", "gw", testTLSServer("a", "a.example.com"), testTLSServer("b", "b.example.com"))
+	d.add(gw)
+	assert.Equal(t, d.Targets.Len(), 2)
+
+	d.update(gw, gw)
+	assert.Equal(t, d.Targets.Len(), 2, "resync should not duplicate targets")
+
+	updated := testGateway("ns", "gw", testTLSServer("b", "b.example.com"))
+	d.update(gw, updated)
+	assert.Equal(t, d.Targets.Len(), 1, "removed server should be pruned")
+	assert.Equal(t, d.Targets.Snapshot()[0].Secret, "b")
+}
+
+func TestDirector_Delete_should_remove_targets(t *testing.T) {
+	d := testDirector()
+	gw := testGateway("ns", "gw", testTLSServer("a", "a.example.com"))
+	other := testGateway("other", "gw", testTLSServer("a", "a.other.com"))
+	d.add(gw)
+	d.add(other)
+
+	d.delete(gw)
+	assert.Equal(t, d.Targets.Len(), 1)
+	assert.Equal(t, d.Targets.Snapshot()[0].Namespace, "other")
+
+	d.delete(cache.DeletedFinalStateUnknown{Key: "other/gw", Obj: other})
+	assert.Equal(t, d.Targets.Len(), 0, "tombstones should be handled")
+}
+
+func TestDirector_EnqueueSecret_should_enqueue_referencing_gateways(t *testing.T) {
+	d := testDirector()
+	d.add(testGateway("ns", "a", testTLSServer("cert", "a.example.com")))
+	d.add(testGateway("ns", "b", testTLSServer("other", "b.example.com")))
+	d.add(testGateway("other", "c", testTLSServer("cert", "c.example.com")))
+
+	d.enqueueSecret(&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "cert"}})
+	assert.Equal(t, d.queue.Len(), 1)
+
+	key, _ := d.queue.Get()
+	assert.Equal(t, key, "ns/a")
+}
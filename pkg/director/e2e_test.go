@@ -0,0 +1,113 @@
+package director
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sFake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
+	"github.com/evry-bergen/waf-syncer/pkg/azure/fake"
+	istioFake "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned/fake"
+	istioInformers "github.com/evry-bergen/waf-syncer/pkg/clients/istio/informers/externalversions"
+	"github.com/evry-bergen/waf-syncer/pkg/config"
+)
+
+type e2eHarness struct {
+	director  *Director
+	server    *fake.Server
+	clientSet *k8sFake.Clientset
+	istio     *istioFake.Clientset
+	stop      chan struct{}
+}
+
+/*
+	A Director wired to fake Kubernetes and Istio clientsets and an ARM stand-in,
+	exercising the real Azure SDK client including long running operations.
+*/
+func newE2EHarness(t *testing.T, secrets ...*v1.Secret) *e2eHarness {
+	gateways := fake.NewApplicationGatewaysClient()
+	gateways.Add("rg", testApplicationGateway())
+	server := fake.NewServer(gateways)
+	server.Polls = 1
+
+	clientSet := k8sFake.NewSimpleClientset()
+	for _, secret := range secrets {
+		clientSet.CoreV1().Secrets(secret.Namespace).Create(secret)
+	}
+	istioClient := istioFake.NewSimpleClientset()
+
+	gatewayInformerFactory := istioInformers.NewSharedInformerFactory(istioClient, 0)
+
+	d := NewDirector(clientSet, istioClient, azure.NewClient(server.Client()),
+		gatewayInformerFactory.Networking().V1alpha3().Gateways(),
+		gatewayInformerFactory.Networking().V1alpha3().VirtualServices())
+	d.AzureWafConfig = &config.AzureWafConfig{
+		ListenerPrefix:      "wd",
+		BackendHttpSettings: "settings",
+		FrontendPort:        "https",
+		BackendPool:         "pool",
+		Name:                "ag",
+		ResourceGroup:       "rg",
+	}
+
+	h := &e2eHarness{director: d, server: server, clientSet: clientSet, istio: istioClient, stop: make(chan struct{})}
+	gatewayInformerFactory.Start(h.stop)
+	d.Run(h.stop)
+
+	return h
+}
+
+func (h *e2eHarness) close() {
+	close(h.stop)
+	h.server.Close()
+}
+
+func (h *e2eHarness) waitForListeners(t *testing.T, count int) {
+	err := wait.PollImmediate(50*time.Millisecond, 10*time.Second, func() (bool, error) {
+		waf, err := h.server.Gateways.Get(context.Background(), "rg", "ag")
+		if err != nil {
+			return false, err
+		}
+		return len(*waf.HTTPListeners) == count, nil
+	})
+
+	if err != nil {
+		t.Fatalf("waiting for %d listeners: %s", count, err)
+	}
+}
+
+func TestDirector_EndToEnd(t *testing.T) {
+	h := newE2EHarness(t, testTLSSecret(t, "ns", "cert", "a.example.com"))
+	defer h.close()
+
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	h.istio.NetworkingV1alpha3().Gateways("ns").Create(gw)
+	h.waitForListeners(t, 1)
+
+	waf, _ := h.server.Gateways.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, *(*waf.HTTPListeners)[0].HostName, "a.example.com")
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	certificate := *(*waf.SslCertificates)[0].PublicCertData
+
+	rotated := testTLSSecret(t, "ns", "cert", "a.example.com")
+	rotated.ResourceVersion = "2"
+	h.clientSet.CoreV1().Secrets("ns").Update(rotated)
+	err := wait.PollImmediate(50*time.Millisecond, 10*time.Second, func() (bool, error) {
+		return h.server.Gateways.Updates() == 2, nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for the rotated certificate: %s", err)
+	}
+	waf, _ = h.server.Gateways.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, *(*waf.SslCertificates)[0].PublicCertData != certificate, true)
+
+	h.istio.NetworkingV1alpha3().Gateways("ns").Delete("gw", &metav1.DeleteOptions{})
+	h.waitForListeners(t, 0)
+	assert.Equal(t, h.server.Gateways.Updates(), 3)
+}
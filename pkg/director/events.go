@@ -0,0 +1,148 @@
+package director
+
+import (
+	"time"
+
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	istioScheme "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned/scheme"
+)
+
+const (
+	// EventReasonSynced - The resources of a Gateway were updated on the AG
+	EventReasonSynced = "Synced"
+	// EventReasonSyncFailed - Azure rejected or failed the AG update with the resources of a Gateway
+	EventReasonSyncFailed = "SyncFailed"
+	// EventReasonSecretMissing - The secret of a Gateway server does not exist
+	EventReasonSecretMissing = "SecretMissing"
+	// EventReasonInvalidCertificate - The secret of a Gateway server has no usable certificate and key
+	EventReasonInvalidCertificate = "InvalidCertificate"
+	// EventReasonCertificateExpiring - The certificate of a Gateway server expires within the warning period
+	EventReasonCertificateExpiring = "CertificateExpiring"
+	// EventReasonCertificateExpired - The certificate of a Gateway server has expired
+	EventReasonCertificateExpired = "CertificateExpired"
+	// EventReasonHostNotCovered - A host of a Gateway server is not in the SANs of its certificate
+	EventReasonHostNotCovered = "HostNotCovered"
+	// EventReasonHostConflict - A host of a Gateway server is already synced from another server
+	EventReasonHostConflict = "HostConflict"
+)
+
+// newEventRecorder - Recorder writing Events through the given clientset
+func newEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedCoreV1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(istioScheme.Scheme, v1.EventSource{Component: "waf-syncer"})
+}
+
+/*
+	Reference to the Gateway of a target to attach Events to. Built by hand, as
+	objects from the informer cache have neither kind nor selfLink, the UID is
+	taken from the cache so the Event shows up on the Gateway.
+*/
+func (d *Director) gatewayRef(target TerminationTarget) *v1.ObjectReference {
+	ref := &v1.ObjectReference{
+		Kind:       "Gateway",
+		APIVersion: istioApiv1alpha3.SchemeGroupVersion.String(),
+		Namespace:  target.Namespace,
+		Name:       target.Gateway,
+	}
+
+	if d.GatewayInformer != nil {
+		if gw, err := d.GatewayInformer.Lister().Gateways(target.Namespace).Get(target.Gateway); err == nil {
+			ref.UID = gw.UID
+			ref.ResourceVersion = gw.ResourceVersion
+		}
+	}
+
+	return ref
+}
+
+func (d *Director) gatewayEvent(target TerminationTarget, eventType string, reason string, messageFmt string, args ...interface{}) {
+	if d.Recorder == nil {
+		return
+	}
+
+	d.Recorder.Eventf(d.gatewayRef(target), eventType, reason, messageFmt, args...)
+}
+
+func (d *Director) warnInvalidSecret(target TerminationTarget, err error) {
+	zap.S().Errorf("Skipping server %d of gateway %s/%s, secret %s: %s",
+		target.Server, target.Namespace, target.Gateway, target.Secret, err)
+
+	if expired, ok := err.(*expiredError); ok {
+		d.gatewayEvent(target, v1.EventTypeWarning, EventReasonCertificateExpired,
+			"Secret %s holds a certificate that expired at %s, hosts %v are left out of the WAF",
+			target.Secret, expired.NotAfter.UTC().Format(time.RFC3339), target.Hosts)
+		return
+	}
+
+	if apiErrors.IsNotFound(err) {
+		d.gatewayEvent(target, v1.EventTypeWarning, EventReasonSecretMissing,
+			"Secret %s does not exist, hosts %v are left out of the WAF", target.Secret, target.Hosts)
+		return
+	}
+
+	d.gatewayEvent(target, v1.EventTypeWarning, EventReasonInvalidCertificate,
+		"Secret %s can't be synced to the WAF, hosts %v are left out: %s", target.Secret, target.Hosts, err)
+}
+
+func (d *Director) warnHostNotCovered(target TerminationTarget, host string) {
+	zap.S().Warnf("Skipping host %s of gateway %s/%s, not covered by the certificate of secret %s",
+		host, target.Namespace, target.Gateway, target.Secret)
+
+	d.gatewayEvent(target, v1.EventTypeWarning, EventReasonHostNotCovered,
+		"Host %s is left out of the WAF, the certificate of secret %s does not cover it", host, target.Secret)
+}
+
+func (d *Director) warnHostConflict(target TerminationTarget, host string, owner TerminationTarget) {
+	zap.S().Warnf("Skipping host %s of gateway %s/%s, already synced from gateway %s/%s",
+		host, target.Namespace, target.Gateway, owner.Namespace, owner.Gateway)
+
+	d.gatewayEvent(target, v1.EventTypeWarning, EventReasonHostConflict,
+		"Host %s is left out of the WAF, it is already synced from gateway %s/%s", host, owner.Namespace, owner.Gateway)
+}
+
+// Number of changed resources per Gateway key, and the owning target of each Gateway
+func changeCounts(changes *ChangeSet, owners map[string]TerminationTarget) (map[string]int, map[string]TerminationTarget) {
+	counts := map[string]int{}
+	targets := map[string]TerminationTarget{}
+	for _, list := range [][]Change{changes.Listeners, changes.Certificates, changes.Rules, changes.Redirects, changes.PathMaps} {
+		for _, change := range list {
+			owner, ok := owners[change.Name]
+			if !ok {
+				continue
+			}
+			key := owner.gatewayKey()
+			counts[key]++
+			targets[key] = owner
+		}
+	}
+
+	return counts, targets
+}
+
+/*
+	Report the outcome of an AG update on every Gateway that owns one of the
+	changed resources. Removed resources usually belong to deleted Gateways and
+	are not reported.
+*/
+func (d *Director) reportUpdate(changes *ChangeSet, owners map[string]TerminationTarget, err error) {
+	counts, targets := changeCounts(changes, owners)
+
+	for key, target := range targets {
+		if err != nil {
+			d.gatewayEvent(target, v1.EventTypeWarning, EventReasonSyncFailed,
+				"Updating WAF %s failed: %s", d.AzureWafConfig.Name, err)
+		} else {
+			d.gatewayEvent(target, v1.EventTypeNormal, EventReasonSynced,
+				"Synced %d resource change(s) to WAF %s", counts[key], d.AzureWafConfig.Name)
+		}
+	}
+}
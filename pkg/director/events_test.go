@@ -0,0 +1,39 @@
+package director
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDirector_Reconcile_should_report_updates_on_gateways(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	agClient.UpdateError = errors.New("conflict")
+	assert.Equal(t, d.reconcile() != nil, true)
+	assert.Equal(t, <-recorder.Events, "Warning SyncFailed Updating WAF ag failed: conflict")
+
+	agClient.UpdateError = nil
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, <-recorder.Events, "Normal Synced Synced 3 resource change(s) to WAF ag")
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, len(recorder.Events), 0, "unchanged gateways should not be reported")
+}
+
+func TestDirector_SyncTargetsToWAF_should_report_host_conflicts(t *testing.T) {
+	d, _ := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "a", testTLSServer("cert", "a.example.com")))
+	d.add(testGateway("ns", "b", testTLSServer("cert", "a.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, <-recorder.Events, "Warning HostConflict Host a.example.com is left out of the WAF, it is already synced from gateway ns/a")
+	assert.Equal(t, <-recorder.Events, "Normal Synced Synced 3 resource change(s) to WAF ag")
+}
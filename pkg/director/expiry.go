@@ -0,0 +1,45 @@
+package director
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+)
+
+// The leaf certificate of a secret has expired and expired certificates are rejected
+type expiredError struct {
+	NotAfter time.Time
+}
+
+func (e *expiredError) Error() string {
+	return fmt.Sprintf("certificate expired at %s", e.NotAfter.UTC().Format(time.RFC3339))
+}
+
+/*
+	Warn on the Gateway of a synced target when its certificate has expired or
+	expires within the warning period. The recorder folds the same message from
+	repeated syncs into a single Event, the countdown starts a new one each day.
+*/
+func (d *Director) checkExpiry(target TerminationTarget, notAfter time.Time) {
+	left := time.Until(notAfter)
+	expires := notAfter.UTC().Format(time.RFC3339)
+
+	if left <= 0 {
+		zap.S().Warnf("Certificate of secret %s/%s expired at %s", target.Namespace, target.Secret, expires)
+		d.gatewayEvent(target, v1.EventTypeWarning, EventReasonCertificateExpired,
+			"Certificate of secret %s expired at %s, hosts %v are served with it", target.Secret, expires, target.Hosts)
+		return
+	}
+
+	if left > d.AzureWafConfig.CertExpiryWarning {
+		return
+	}
+
+	days := int(math.Ceil(left.Hours() / 24))
+	zap.S().Infof("Certificate of secret %s/%s expires at %s", target.Namespace, target.Secret, expires)
+	d.gatewayEvent(target, v1.EventTypeWarning, EventReasonCertificateExpiring,
+		"Certificate of secret %s expires at %s, in %d day(s)", target.Secret, expires, days)
+}
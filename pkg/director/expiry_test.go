@@ -0,0 +1,89 @@
+package director
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func testExpiredTLSSecret(t *testing.T, namespace string, name string, hosts ...string) *v1.Secret {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	return testTLSSecretUntil(t, namespace, name, key, keyBlock, time.Now().Add(-time.Hour), hosts...)
+}
+
+func TestDirector_SyncTargetsToWAF_should_warn_on_expiring_certificates(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.AzureWafConfig.CertExpiryWarning = 48 * time.Hour
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	assert.Matches(t, <-recorder.Events, "^Warning CertificateExpiring Certificate of secret cert expires at .*, in 1 day\\(s\\)$")
+
+	d.AzureWafConfig.CertExpiryWarning = time.Hour
+	d.syncTargetsToWAF(&waf)
+	assert.Equal(t, len(recorder.Events), 0)
+}
+
+func TestDirector_Reconcile_should_sync_expired_certificates_with_a_warning(t *testing.T) {
+	d, agClient := testReconcileDirector(testExpiredTLSSecret(t, "ns", "cert", "a.example.com"))
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	assert.Matches(t, <-recorder.Events, "^Warning CertificateExpired Certificate of secret cert expired at .*")
+	assert.Equal(t, testutil.ToFloat64(certificateExpiry.WithLabelValues("ns", "gw", "cert")) < float64(time.Now().Unix()), true)
+}
+
+func TestDirector_Reconcile_should_reject_expired_certificates(t *testing.T) {
+	d, agClient := testReconcileDirector(testExpiredTLSSecret(t, "ns", "expired", "a.example.com"), testTLSSecret(t, "ns", "cert", "b.example.com"))
+	d.AzureWafConfig.RejectExpiredCerts = true
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("expired", "a.example.com"), testTLSServer("cert", "b.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 1)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].HostName, "b.example.com")
+	assert.Matches(t, <-recorder.Events, "^Warning CertificateExpired Secret expired holds a certificate that expired at .*")
+	assert.Equal(t, testutil.ToFloat64(skippedTargets), 1.0)
+}
+
+func TestDirector_Reconcile_should_not_record_expiry_of_pruned_certificates(t *testing.T) {
+	d, _ := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.AzureWafConfig.CertExpiryWarning = 48 * time.Hour
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "b.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+
+	err := testutil.CollectAndCompare(certificateExpiry, strings.NewReader(""), "waf_syncer_certificate_expiry_timestamp_seconds")
+	assert.Equal(t, err, nil)
+	assert.Matches(t, <-recorder.Events, "^Warning HostNotCovered ")
+	assert.Equal(t, len(recorder.Events), 0, "unused certificates should not warn on expiry")
+}
@@ -0,0 +1,72 @@
+package director
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	How long a successful AG Get keeps the syncer ready, so probes don't turn
+	into a steady stream of ARM requests
+*/
+const readyCacheTTL = 30 * time.Second
+
+var errCachesNotSynced = errors.New("informer caches are not synced")
+
+type readiness struct {
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (d *Director) startReconcile() {
+	atomic.StoreInt64(&d.reconcileStarted, time.Now().UnixNano())
+}
+
+func (d *Director) finishReconcile() {
+	atomic.StoreInt64(&d.reconcileStarted, 0)
+}
+
+/*
+	Healthy - Fails when a reconcile has been running for longer than the
+	liveness deadline, e.g. waiting forever on an AG operation
+*/
+func (d *Director) Healthy() error {
+	started := atomic.LoadInt64(&d.reconcileStarted)
+	if started == 0 || d.AzureWafConfig.LivenessDeadline <= 0 {
+		return nil
+	}
+
+	running := time.Since(time.Unix(0, started))
+	if running > d.AzureWafConfig.LivenessDeadline {
+		return fmt.Errorf("sync has been running for %s, longer than %s", running.Round(time.Second), d.AzureWafConfig.LivenessDeadline)
+	}
+
+	return nil
+}
+
+/*
+	Ready - Succeeds once the Gateway cache is synced and the AG could be read
+*/
+func (d *Director) Ready(ctx context.Context) error {
+	if d.GatewayInformerSynced == nil || !d.GatewayInformerSynced() {
+		return errCachesNotSynced
+	}
+
+	d.readiness.lock.Lock()
+	defer d.readiness.lock.Unlock()
+
+	if d.readiness.err == nil && !d.readiness.checked.IsZero() && time.Since(d.readiness.checked) < readyCacheTTL {
+		return nil
+	}
+
+	_, err := d.AzureAGClient.Get(ctx, d.AzureWafConfig.ResourceGroup, d.AzureWafConfig.Name)
+	d.readiness.checked = time.Now()
+	d.readiness.err = err
+
+	return err
+}
@@ -0,0 +1,58 @@
+package director
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/magiconair/properties/assert"
+
+	"github.com/evry-bergen/waf-syncer/pkg/azure"
+)
+
+type countingClient struct {
+	azure.ApplicationGatewaysClient
+	gets int
+}
+
+func (c *countingClient) Get(ctx context.Context, resourceGroupName string, applicationGatewayName string) (azureNetwork.ApplicationGateway, error) {
+	c.gets++
+	return c.ApplicationGatewaysClient.Get(ctx, resourceGroupName, applicationGatewayName)
+}
+
+func TestDirector_Healthy_should_fail_on_stuck_sync(t *testing.T) {
+	d, _ := testReconcileDirector()
+	d.AzureWafConfig.LivenessDeadline = time.Minute
+	assert.Equal(t, d.Healthy(), nil)
+
+	d.startReconcile()
+	assert.Equal(t, d.Healthy(), nil)
+
+	d.reconcileStarted = time.Now().Add(-2 * time.Minute).UnixNano()
+	assert.Equal(t, d.Healthy() != nil, true)
+
+	d.finishReconcile()
+	assert.Equal(t, d.Healthy(), nil)
+}
+
+func TestDirector_Ready_should_require_synced_caches_and_ag(t *testing.T) {
+	d, agClient := testReconcileDirector()
+	client := &countingClient{ApplicationGatewaysClient: agClient}
+	d.AzureAGClient = client
+
+	synced := false
+	d.GatewayInformerSynced = func() bool { return synced }
+	assert.Equal(t, d.Ready(context.Background()), errCachesNotSynced)
+
+	synced = true
+	assert.Equal(t, d.Ready(context.Background()), nil)
+	assert.Equal(t, d.Ready(context.Background()), nil)
+	assert.Equal(t, client.gets, 1, "successful checks should be cached")
+
+	d.readiness = readiness{}
+	d.AzureWafConfig.Name = "missing"
+	assert.Equal(t, d.Ready(context.Background()) != nil, true)
+	assert.Equal(t, d.Ready(context.Background()) != nil, true)
+	assert.Equal(t, client.gets, 3, "failed checks should be retried")
+}
@@ -0,0 +1,137 @@
+package director
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "waf_syncer"
+
+var (
+	syncAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_attempts_total",
+		Help:      "Number of times the WAF was reconciled.",
+	})
+	syncSuccesses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_successes_total",
+		Help:      "Number of reconciles that left the WAF up to date.",
+	})
+	syncFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_failures_total",
+		Help:      "Number of reconciles that failed and will be retried.",
+	})
+	updateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ag_update_duration_seconds",
+		Help:      "Duration of Application Gateway updates, until the operation completed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+	})
+	lastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Unix time of the last reconcile that left the WAF up to date.",
+	})
+	managedResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "managed_resources",
+		Help:      "Number of WAF resources managed by the syncer, by kind.",
+	}, []string{"kind"})
+	skippedTargets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "skipped_targets",
+		Help:      "Number of Gateway servers left out of the last sync because of secret errors.",
+	})
+	provisioningState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "ag_provisioning_state",
+		Help:      "Provisioning state of the Application Gateway, 1 for the current state.",
+	}, []string{"state"})
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Unix time the synced certificate of a Gateway secret expires.",
+	}, []string{"namespace", "gateway", "secret"})
+	leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "1 while this replica syncs the WAF, 0 while it is a follower waiting for the lease.",
+	})
+	lastChangeSet = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_change_set",
+		Help:      "Changes to the WAF computed by the last reconcile, by action. In dry run these were not applied.",
+	}, []string{"action"})
+
+	lastSuccessUnix = time.Now().Unix()
+)
+
+func init() {
+	prometheus.MustRegister(syncAttempts, syncSuccesses, syncFailures, updateDuration, lastSuccessfulSync,
+		managedResources, skippedTargets, provisioningState, certificateExpiry, leader, lastChangeSet)
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "seconds_since_last_successful_sync",
+		Help:      "Seconds since the last reconcile that left the WAF up to date, or since this replica started syncing before the first one.",
+	}, func() float64 {
+		return time.Since(time.Unix(atomic.LoadInt64(&lastSuccessUnix), 0)).Seconds()
+	}))
+}
+
+func recordSyncResult(err error) {
+	if err != nil {
+		syncFailures.Inc()
+		return
+	}
+
+	now := time.Now()
+	syncSuccesses.Inc()
+	lastSuccessfulSync.Set(float64(now.Unix()))
+	atomic.StoreInt64(&lastSuccessUnix, now.Unix())
+}
+
+/*
+	Whether this replica syncs the WAF. Followers never sync, so a new leader
+	counts the time since the last successful sync from taking over.
+*/
+func recordLeader(leading bool) {
+	if !leading {
+		leader.Set(0)
+		return
+	}
+
+	leader.Set(1)
+	atomic.StoreInt64(&lastSuccessUnix, time.Now().Unix())
+}
+
+func recordProvisioningState(state string) {
+	provisioningState.Reset()
+	provisioningState.WithLabelValues(state).Set(1)
+}
+
+func recordManagedResources(state managedState) {
+	managedResources.WithLabelValues("listener").Set(float64(len(state.Listeners)))
+	managedResources.WithLabelValues("certificate").Set(float64(len(state.Certificates)))
+	managedResources.WithLabelValues("rule").Set(float64(len(state.Rules)))
+	managedResources.WithLabelValues("redirect_configuration").Set(float64(len(state.Redirects)))
+	managedResources.WithLabelValues("url_path_map").Set(float64(len(state.PathMaps)))
+}
+
+func (d *Director) recordCertificateExpiry(result *syncResult) {
+	certificateExpiry.Reset()
+	for _, target := range result.Synced {
+		notAfter := result.CertificateExpiry[result.Certificates[target.secretKey()]]
+		certificateExpiry.WithLabelValues(target.Namespace, target.Gateway, target.Secret).Set(float64(notAfter.Unix()))
+	}
+}
+
+func recordChangeSet(changes *ChangeSet) {
+	for action, count := range changes.Summary() {
+		lastChangeSet.WithLabelValues(string(action)).Set(float64(count))
+	}
+}
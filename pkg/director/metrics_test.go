@@ -0,0 +1,33 @@
+package director
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDirector_Reconcile_should_record_metrics(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com"), testTLSServer("missing", "b.example.com")))
+
+	attempts := testutil.ToFloat64(syncAttempts)
+	successes := testutil.ToFloat64(syncSuccesses)
+	failures := testutil.ToFloat64(syncFailures)
+
+	agClient.UpdateError = errors.New("conflict")
+	assert.Equal(t, d.reconcile() != nil, true)
+	assert.Equal(t, testutil.ToFloat64(syncFailures), failures+1)
+
+	agClient.UpdateError = nil
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, testutil.ToFloat64(syncAttempts), attempts+2)
+	assert.Equal(t, testutil.ToFloat64(syncSuccesses), successes+1)
+
+	assert.Equal(t, testutil.ToFloat64(managedResources.WithLabelValues("listener")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(managedResources.WithLabelValues("certificate")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(managedResources.WithLabelValues("rule")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(skippedTargets), 1.0)
+	assert.Equal(t, testutil.ToFloat64(provisioningState.WithLabelValues("Succeeded")), 1.0)
+}
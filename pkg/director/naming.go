@@ -0,0 +1,104 @@
+package director
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/*
+	AG child resource names are at most 80 characters of letters, digits,
+	underscores, periods and hyphens, starting with a letter or digit and ending
+	with a letter, digit or underscore.
+*/
+const (
+	maxNameLength  = 80
+	nameHashLength = 8
+)
+
+func validNameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-'
+}
+
+/*
+	Replace what AG does not accept in a name, a wildcard label becomes
+	"wildcard" so *.example.com stays readable
+*/
+func sanitizeName(raw string) string {
+	name := strings.Map(func(c rune) rune {
+		if validNameChar(c) {
+			return c
+		}
+		return '-'
+	}, strings.Replace(raw, "*", "wildcard", -1))
+
+	name = strings.TrimLeft(name, "_.-")
+	return strings.TrimRight(name, ".-")
+}
+
+func nameHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+/*
+	Name from the joined parts. Names that are valid as they are stay unchanged,
+	others are sanitized and get a hash of the original, also cutting them to
+	length. So different originals never end up with the same name.
+*/
+func resourceName(parts ...string) string {
+	raw := strings.Join(parts, "-")
+	name := sanitizeName(raw)
+	if name == raw && len(name) <= maxNameLength {
+		return name
+	}
+
+	return hashedName(name, raw)
+}
+
+func hashedName(name string, raw string) string {
+	if max := maxNameLength - nameHashLength - 1; len(name) > max {
+		name = strings.TrimRight(name[:max], ".-")
+	}
+
+	return fmt.Sprintf("%s-%s", name, nameHash(raw))
+}
+
+/*
+	namer - Hands out the names of one sync. The same parts always get the same
+	name, so duplicate hosts are still detected by name. AG compares names
+	case-insensitively, so a name only differing in case is taken too. It
+	remembers what each name was made for, to show the host or secret next to
+	unreadable names.
+*/
+type namer struct {
+	raws    map[string]string
+	sources map[string]string
+}
+
+func newNamer() *namer {
+	return &namer{raws: map[string]string{}, sources: map[string]string{}}
+}
+
+func (n *namer) name(source string, parts ...string) string {
+	raw := strings.Join(parts, "-")
+	name := resourceName(parts...)
+
+	for i := 1; ; i++ {
+		taken, ok := n.raws[strings.ToLower(name)]
+		if !ok || taken == raw {
+			break
+		}
+		name = hashedName(sanitizeName(raw), fmt.Sprintf("%s#%d", raw, i))
+	}
+
+	n.raws[strings.ToLower(name)] = raw
+	n.sources[name] = source
+	return name
+}
+
+// Source - What the named resource was created for, empty when unknown
+func (n *namer) source(name string) string {
+	return n.sources[name]
+}
@@ -0,0 +1,54 @@
+package director
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestResourceName_should_keep_valid_names(t *testing.T) {
+	assert.Equal(t, resourceName("wd", "a.example.com", "tls"), "wd-a.example.com-tls")
+}
+
+func TestResourceName_should_sanitize_and_hash(t *testing.T) {
+	name := resourceName("wd", "*.example.com", "tls")
+	assert.Matches(t, name, "^wd-wildcard.example.com-tls-[0-9a-f]{8}$")
+	assert.Equal(t, name == resourceName("wd", "wildcard.example.com", "tls"), false)
+
+	long := resourceName("wd", strings.Repeat("a", 70)+".example.com", "tls")
+	assert.Equal(t, len(long), maxNameLength)
+	assert.Equal(t, long == resourceName("wd", strings.Repeat("a", 70)+".example.org", "tls"), false)
+
+	assert.Equal(t, sanitizeName("-a b/c."), "a-b-c")
+}
+
+func TestNamer_should_hand_out_unique_names(t *testing.T) {
+	n := newNamer()
+	a := n.name("a", "wd", "a.example.com", "tls")
+	assert.Equal(t, n.name("a", "wd", "a.example.com", "tls"), a, "same parts should get the same name")
+
+	hashed := resourceName("wd", "*.example.com", "tls")
+	n.raws[hashed] = "something else"
+	assert.Equal(t, n.name("*.example.com", "wd", "*.example.com", "tls") == hashed, false)
+
+	upper := n.name("A", "wd", "A.example.com", "tls")
+	assert.Equal(t, strings.EqualFold(upper, a), false, "AG names are case-insensitive")
+
+	assert.Equal(t, n.source(a), "a")
+	assert.Equal(t, n.source("unknown"), "")
+}
+
+func TestDirector_Plan_should_name_the_catch_all_host(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "*")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	changes, _, _ := d.desiredChanges(&waf)
+
+	assert.Equal(t, len(changes.Listeners), 1)
+	assert.Matches(t, changes.Listeners[0].Name, "^wd-wildcard-tls-[0-9a-f]{8}$")
+	assert.Equal(t, changes.Listeners[0].Source, "*")
+	assert.Equal(t, changes.Certificates[0].Source, "ns/cert")
+}
@@ -0,0 +1,162 @@
+package director
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var changeSymbols = map[ChangeAction]string{
+	Added:   "+",
+	Changed: "~",
+	Removed: "-",
+}
+
+// Plan - Compute the changes a sync would make to the AG without applying them
+func (d *Director) Plan() (*ChangeSet, error) {
+	if err := d.loadTargets(); err != nil {
+		return nil, err
+	}
+
+	waf, err := d.AzureAGClient.Get(context.Background(), d.AzureWafConfig.ResourceGroup, d.AzureWafConfig.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, _, _ := d.desiredChanges(&waf)
+	return changes, nil
+}
+
+/*
+	Build the targets straight from the Gateway lister, informer event handlers
+	might not have seen every Gateway yet when the caches report synced.
+*/
+func (d *Director) loadTargets() error {
+	gateways, err := d.GatewayInformer.Lister().List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, gw := range gateways {
+		d.update(nil, gw)
+	}
+
+	return nil
+}
+
+// Summary - Count of changes by action
+func (c *ChangeSet) Summary() map[ChangeAction]int {
+	summary := map[ChangeAction]int{Added: 0, Changed: 0, Removed: 0}
+	for _, list := range [][]Change{c.Listeners, c.Certificates, c.Rules, c.Redirects, c.PathMaps} {
+		for _, change := range list {
+			summary[change.Action]++
+		}
+	}
+
+	return summary
+}
+
+// WriteJSON - Write the change set as indented JSON
+func (c *ChangeSet) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
+// WriteText - Write the change set in a human readable form
+func (c *ChangeSet) WriteText(w io.Writer) error {
+	sections := []struct {
+		title   string
+		changes []Change
+	}{
+		{"Listeners", c.Listeners},
+		{"Certificates", c.Certificates},
+		{"Routing rules", c.Rules},
+		{"Redirect configurations", c.Redirects},
+		{"URL path maps", c.PathMaps},
+	}
+
+	for _, section := range sections {
+		if len(section.changes) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\n", section.title)
+		for _, change := range section.changes {
+			if change.Source != "" {
+				fmt.Fprintf(w, "  %s %s (%s)\n", changeSymbols[change.Action], change.Name, change.Source)
+			} else {
+				fmt.Fprintf(w, "  %s %s\n", changeSymbols[change.Action], change.Name)
+			}
+			if err := writeProperties(w, change); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	summary := c.Summary()
+	_, err := fmt.Fprintf(w, "Plan: %d to add, %d to change, %d to remove.\n", summary[Added], summary[Changed], summary[Removed])
+	return err
+}
+
+func specProperties(spec interface{}) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	if spec == nil {
+		return properties, nil
+	}
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, &properties)
+	return properties, err
+}
+
+/*
+	Write the properties of a change, for changed resources only the ones that
+	differ
+*/
+func writeProperties(w io.Writer, change Change) error {
+	before, err := specProperties(change.Before)
+	if err != nil {
+		return err
+	}
+
+	after, err := specProperties(change.After)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch change.Action {
+		case Added:
+			fmt.Fprintf(w, "      %s: %v\n", key, after[key])
+		case Removed:
+			fmt.Fprintf(w, "      %s: %v\n", key, before[key])
+		case Changed:
+			if fmt.Sprint(before[key]) != fmt.Sprint(after[key]) {
+				fmt.Fprintf(w, "      %s: %v -> %v\n", key, before[key], after[key])
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package director
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func testChangeSet() *ChangeSet {
+	return &ChangeSet{
+		Listeners: []Change{
+			{Action: Added, Name: "wd-a-tls", Source: "a", After: listenerSpec{HostName: "a", Protocol: "Https"}},
+			{Action: Changed, Name: "wd-b-tls", Before: listenerSpec{HostName: "b", Protocol: "Https"}, After: listenerSpec{HostName: "c", Protocol: "Https"}},
+		},
+		Certificates: []Change{
+			{Action: Removed, Name: "wd-ns-cert", Before: certificateSpec{Fingerprint: "ab"}},
+		},
+		Rules: []Change{},
+	}
+}
+
+func TestChangeSet_WriteText(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := testChangeSet().WriteText(out)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, out.String(), `Listeners:
+  + wd-a-tls (a)
+      frontendIPConfiguration: 
+      frontendPort: 
+      hostName: a
+      protocol: Https
+  ~ wd-b-tls
+      hostName: b -> c
+
+Certificates:
+  - wd-ns-cert
+      fingerprint: ab
+
+Plan: 1 to add, 1 to change, 1 to remove.
+`)
+}
+
+func TestChangeSet_WriteJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := testChangeSet().WriteJSON(out)
+	assert.Equal(t, err, nil)
+
+	parsed := ChangeSet{}
+	err = json.Unmarshal(out.Bytes(), &parsed)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(parsed.Listeners), 2)
+	assert.Equal(t, parsed.Certificates[0].Action, Removed)
+}
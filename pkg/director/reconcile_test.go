@@ -0,0 +1,416 @@
+package director
+
+import (
+	"context"
+	stdCrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sFake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/evry-bergen/waf-syncer/pkg/azure/fake"
+	"github.com/evry-bergen/waf-syncer/pkg/config"
+)
+
+func testTLSSecret(t *testing.T, namespace string, name string, hosts ...string) *v1.Secret {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	return testTLSSecretWithKey(t, namespace, name, key, keyBlock, hosts...)
+}
+
+func testTLSSecretWithKey(t *testing.T, namespace string, name string, key stdCrypto.Signer, keyBlock *pem.Block, hosts ...string) *v1.Secret {
+	return testTLSSecretUntil(t, namespace, name, key, keyBlock, time.Now().Add(24*time.Hour), hosts...)
+}
+
+func testTLSSecretUntil(t *testing.T, namespace string, name string, key stdCrypto.Signer, keyBlock *pem.Block, notAfter time.Time, hosts ...string) *v1.Secret {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Type:       v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			"tls.key": pem.EncodeToMemory(keyBlock),
+		},
+	}
+}
+
+func testApplicationGateway() azureNetwork.ApplicationGateway {
+	return azureNetwork.ApplicationGateway{
+		Name: to.StringPtr("ag"),
+		ApplicationGatewayPropertiesFormat: &azureNetwork.ApplicationGatewayPropertiesFormat{
+			FrontendIPConfigurations: &[]azureNetwork.ApplicationGatewayFrontendIPConfiguration{{Name: to.StringPtr("ip")}},
+			FrontendPorts: &[]azureNetwork.ApplicationGatewayFrontendPort{
+				{Name: to.StringPtr("https")},
+				{Name: to.StringPtr("http")},
+			},
+			BackendAddressPools: &[]azureNetwork.ApplicationGatewayBackendAddressPool{{Name: to.StringPtr("pool")}},
+			BackendHTTPSettingsCollection: &[]azureNetwork.ApplicationGatewayBackendHTTPSettings{
+				{Name: to.StringPtr("settings")},
+			},
+			HTTPListeners:          &[]azureNetwork.ApplicationGatewayHTTPListener{},
+			SslCertificates:        &[]azureNetwork.ApplicationGatewaySslCertificate{},
+			RequestRoutingRules:    &[]azureNetwork.ApplicationGatewayRequestRoutingRule{},
+			RedirectConfigurations: &[]azureNetwork.ApplicationGatewayRedirectConfiguration{},
+		},
+	}
+}
+
+func testReconcileDirector(secrets ...*v1.Secret) (*Director, *fake.ApplicationGatewaysClient) {
+	agClient := fake.NewApplicationGatewaysClient()
+	agClient.Add("rg", testApplicationGateway())
+
+	clientSet := k8sFake.NewSimpleClientset()
+	for _, secret := range secrets {
+		clientSet.CoreV1().Secrets(secret.Namespace).Create(secret)
+	}
+
+	d := testDirector()
+	d.AzureWafConfig = &config.AzureWafConfig{
+		ListenerPrefix:      "wd",
+		BackendHttpSettings: "settings",
+		FrontendPort:        "https",
+		HTTPFrontendPort:    "http",
+		BackendPool:         "pool",
+		Name:                "ag",
+		ResourceGroup:       "rg",
+	}
+	d.AzureAGClient = agClient
+	d.ClientSet = clientSet
+	d.secrets = newSecretWatcher(clientSet, d.enqueueSecret)
+
+	return d, agClient
+}
+
+func TestDirector_SyncTargetsToWAF_should_create_listeners_certificates_and_rules(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com", "b.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com", "b.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	result := d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].Name, "wd-a.example.com-tls")
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	assert.Matches(t, *(*waf.SslCertificates)[0].Name, "^wd-cert-[0-9a-f]{16}$")
+	assert.Equal(t, len(*waf.RequestRoutingRules), 2)
+	assert.Equal(t, len(result.CertificateFingerprints), 1)
+}
+
+func TestDirector_Reconcile_should_update_only_on_changes(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	d.add(gw)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1, "unchanged targets should not update the AG")
+
+	d.delete(gw)
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 2)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 0)
+	assert.Equal(t, len(*waf.SslCertificates), 0)
+	assert.Equal(t, len(*waf.RequestRoutingRules), 0)
+}
+
+func TestDirector_Reconcile_should_not_update_in_dry_run(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.AzureWafConfig.DryRun = true
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 0)
+	assert.Equal(t, len(d.LastChanges().Listeners), 1)
+	assert.Equal(t, testutil.ToFloat64(lastChangeSet.WithLabelValues(string(Added))), 3.0)
+}
+
+func TestDirector_ProcessNextBatch_should_retry_failed_updates(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+	agClient.UpdateError = errors.New("conflict")
+
+	d.queue.Add("ns/gw")
+	d.queue.Add(resyncKey)
+	assert.Equal(t, d.processNextBatch(make(chan struct{})), true)
+	assert.Equal(t, agClient.Updates(), 0)
+	assert.Equal(t, d.queue.NumRequeues("ns/gw"), 1)
+
+	agClient.UpdateError = nil
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1)
+}
+
+func TestDirector_ProcessNextBatch_should_not_reconcile_when_stopped(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+	d.AzureWafConfig.SyncDebounce = time.Hour
+
+	stop := make(chan struct{})
+	close(stop)
+	d.queue.Add("ns/gw")
+	assert.Equal(t, d.processNextBatch(stop), false)
+	assert.Equal(t, agClient.Updates(), 0)
+	assert.Equal(t, d.queue.Len(), 0)
+
+	// Done, so the key can be queued and processed again
+	d.queue.Add("ns/gw")
+	assert.Equal(t, d.queue.Len(), 1)
+}
+
+func TestDirector_SyncTargetsToWAF_should_use_gateway_annotations(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	gw.Annotations = map[string]string{
+		AnnotationBackendPool:  "internal",
+		AnnotationHTTPSettings: "internal-settings",
+		AnnotationFrontendPort: "internal-port",
+	}
+	d.add(gw)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	listener := (*waf.HTTPListeners)[0]
+	assert.Equal(t, *listener.FrontendPort.ID, *waf.ID+"/frontEndPorts/internal-port")
+	rule := (*waf.RequestRoutingRules)[0]
+	assert.Equal(t, *rule.BackendAddressPool.ID, *waf.ID+"/backendAddressPools/internal")
+	assert.Equal(t, *rule.BackendHTTPSettings.ID, *waf.ID+"/backendHttpSettingsCollection/internal-settings")
+}
+
+func TestDirector_Reconcile_should_manage_https_redirects(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	redirect := istioApiv1alpha3.Server{
+		Hosts: []string{"a.example.com"},
+		TLS:   &istioApiv1alpha3.TLSOptions{HTTPSRedirect: true},
+	}
+	gw := testGateway("ns", "gw", redirect, testTLSServer("cert", "a.example.com"))
+	d.add(gw)
+	assert.Equal(t, d.Targets.Len(), 1, "the redirect server has no secret to sync")
+
+	assert.Equal(t, d.reconcile(), nil)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+	assert.Equal(t, len(*waf.RequestRoutingRules), 2)
+	assert.Equal(t, len(*waf.RedirectConfigurations), 1)
+
+	configuration := (*waf.RedirectConfigurations)[0]
+	assert.Equal(t, *configuration.Name, "wd-a.example.com-http")
+	assert.Equal(t, configuration.RedirectType, azureNetwork.Permanent)
+	assert.Equal(t, *configuration.TargetListener.ID, *waf.ID+"/httpListeners/wd-a.example.com-tls")
+	assert.Equal(t, *configuration.IncludePath, true)
+	assert.Equal(t, *configuration.IncludeQueryString, true)
+	assert.Equal(t, testutil.ToFloat64(skippedTargets), 0.0)
+	assert.Matches(t, <-recorder.Events, "^Normal Synced ")
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1, "unchanged redirects should not update the AG")
+
+	gw = gw.DeepCopy()
+	gw.Annotations = map[string]string{AnnotationHTTPSRedirect: "false"}
+	d.update(nil, gw)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 2)
+
+	waf, _ = agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 1)
+	assert.Equal(t, len(*waf.RequestRoutingRules), 1)
+	assert.Equal(t, len(*waf.RedirectConfigurations), 0)
+}
+
+func TestDirector_Reconcile_should_upload_ecdsa_certificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := testTLSSecretWithKey(t, "ns", "cert", key, &pem.Block{Type: "PRIVATE KEY", Bytes: der}, "a.example.com")
+	d, agClient := testReconcileDirector(secret)
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1, "uploaded certificate should match its secret")
+}
+
+func TestDirector_Reconcile_should_skip_targets_with_invalid_secrets(t *testing.T) {
+	broken := testTLSSecret(t, "ns", "broken", "b.example.com")
+	broken.Data["tls.key"] = []byte("not a key")
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"), broken)
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "good", testTLSServer("cert", "a.example.com")))
+	d.add(testGateway("ns", "bad", testTLSServer("broken", "b.example.com"), testTLSServer("missing", "c.example.com")))
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 1)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].Name, "wd-a.example.com-tls")
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	assert.Equal(t, len(*waf.RequestRoutingRules), 1)
+
+	assert.Equal(t, len(recorder.Events), 3)
+	assert.Matches(t, <-recorder.Events, "^Warning InvalidCertificate Secret broken .*")
+	assert.Matches(t, <-recorder.Events, "^Warning SecretMissing Secret missing .*")
+}
+
+func TestDirector_SyncTargetsToWAF_should_share_certificates_between_targets(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com", "b.example.com"))
+	d.add(testGateway("ns", "a", testTLSServer("cert", "a.example.com")))
+	d.add(testGateway("ns", "b", testTLSServer("cert", "b.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+}
+
+func TestDirector_SyncTargetsToWAF_should_deduplicate_certificates_across_namespaces(t *testing.T) {
+	wildcard := testTLSSecret(t, "a", "wildcard", "*.example.com")
+	copied := wildcard.DeepCopy()
+	copied.Namespace = "b"
+	d, agClient := testReconcileDirector(wildcard, copied)
+	d.add(testGateway("a", "gw", testTLSServer("wildcard", "a.example.com")))
+	d.add(testGateway("b", "gw", testTLSServer("wildcard", "b.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	result := d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+	name := *(*waf.SslCertificates)[0].Name
+	assert.Equal(t, result.Certificates["a/wildcard"], name)
+	assert.Equal(t, result.Certificates["b/wildcard"], name)
+	for _, listener := range *waf.HTTPListeners {
+		assert.Matches(t, *listener.SslCertificate.ID, "/sslCertificates/"+name+"$")
+	}
+}
+
+func TestDirector_SyncTargetsToWAF_should_prune_unreferenced_certificates(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "b.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	result := d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 0)
+	assert.Equal(t, len(*waf.SslCertificates), 0)
+	assert.Equal(t, len(result.CertificateFingerprints), 0)
+}
+
+func TestDirector_SyncTargetsToWAF_should_skip_hosts_not_covered_by_the_certificate(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com", "*.apps.example.com"))
+	recorder := record.NewFakeRecorder(10)
+	d.Recorder = recorder
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com", "b.apps.example.com", "b.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	result := d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].HostName, "a.example.com")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].HostName, "b.apps.example.com")
+	assert.Equal(t, <-recorder.Events, "Warning HostNotCovered Host b.example.com is left out of the WAF, the certificate of secret cert does not cover it")
+	assert.Equal(t, result.Errors["ns/gw"], []string{"host b.example.com is not covered by the certificate of secret cert"})
+}
+
+func TestDirector_SyncTargetsToWAF_should_map_catch_all_and_wildcard_hosts(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "example.com", "*.example.com"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "*", "*.example.com", "example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	result := d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 3)
+	assert.Equal(t, (*waf.HTTPListeners)[0].HostName == nil, true, "catch-all should be a basic listener")
+	assert.Equal(t, (*waf.HTTPListeners)[0].HostNames == nil, true, "catch-all should be a basic listener")
+	assert.Equal(t, (*waf.HTTPListeners)[1].HostName == nil, true, "wildcards only go in the host names list")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].HostNames, []string{"*.example.com"})
+	assert.Equal(t, *(*waf.HTTPListeners)[2].HostName, "example.com")
+	assert.Equal(t, len(result.Errors), 0)
+}
+
+func TestDirector_Reconcile_should_share_listeners_with_multi_host_listener(t *testing.T) {
+	hosts := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com", "*.example.com"}
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", append([]string{"*.example.com"}, hosts...)...))
+	d.AzureWafConfig.MultiHostListeners = true
+	d.add(testGateway("ns", "gw", testTLSServer("cert", append([]string{"*"}, hosts...)...)))
+
+	assert.Equal(t, d.reconcile(), nil)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, len(*waf.HTTPListeners), 3)
+	assert.Matches(t, *(*waf.HTTPListeners)[0].Name, "^wd-wildcard-tls-[0-9a-f]{8}$")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].Name, "wd-ns-gw-0-0-tls")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].HostNames, hosts[:maxListenerHostNames])
+	assert.Matches(t, *(*waf.HTTPListeners)[2].Name, "^wd-wildcard.example.com-tls-[0-9a-f]{8}$")
+	assert.Equal(t, *(*waf.HTTPListeners)[2].HostNames, []string{"*.example.com"})
+	assert.Equal(t, len(*waf.RequestRoutingRules), 3)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1, "host names should compare unchanged")
+}
+
+func TestDirector_SyncTargetsToWAF_multi_host_listener_annotation_should_override_the_flag(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com", "b.example.com"))
+	d.AzureWafConfig.MultiHostListeners = true
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com", "b.example.com"))
+	gw.Annotations = map[string]string{AnnotationMultiHostListener: "false"}
+	d.add(gw)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].HostName, "a.example.com")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].HostName, "b.example.com")
+}
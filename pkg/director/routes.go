@@ -0,0 +1,221 @@
+package director
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+/*
+	Gateways a VirtualService is bound to, as namespace/name keys. Istio resolves
+	names without a namespace to the namespace of the VirtualService.
+*/
+func virtualServiceGateways(vs *istioApiv1alpha3.VirtualService) []string {
+	keys := []string{}
+	for _, gateway := range vs.Spec.Gateways {
+		if gateway == "mesh" {
+			continue
+		}
+		if !strings.Contains(gateway, "/") {
+			gateway = fmt.Sprintf("%s/%s", vs.Namespace, gateway)
+		}
+		keys = append(keys, gateway)
+	}
+
+	return keys
+}
+
+/*
+	Enqueue every Gateway the given VirtualService is bound to
+*/
+func (d *Director) enqueueVirtualService(obj interface{}) {
+	vs, ok := obj.(*istioApiv1alpha3.VirtualService)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			zap.S().Errorf("Couldn't get object from tombstone %#v", obj)
+			return
+		}
+
+		vs, ok = tombstone.Obj.(*istioApiv1alpha3.VirtualService)
+		if !ok {
+			zap.S().Errorf("Tombstone contained object that is not a VirtualService %#v", tombstone.Obj)
+			return
+		}
+	}
+
+	for _, key := range virtualServiceGateways(vs) {
+		zap.S().Debugf("VirtualService %s/%s changed, resyncing gateway %s", vs.Namespace, vs.Name, key)
+		d.enqueue(key)
+	}
+}
+
+func hostMatches(pattern string, host string) bool {
+	if pattern == "*" || pattern == host {
+		return true
+	}
+
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])
+}
+
+/*
+	The AG path of an Istio URI match. AG only knows exact paths and prefixes
+	ending in *, regex and suffix matches can't be expressed.
+*/
+func uriPath(match istioApiv1alpha3.HTTPMatchRequest) string {
+	uri := match.URI
+	if uri == nil {
+		return ""
+	}
+
+	switch {
+	case uri.Exact != "":
+		return uri.Exact
+	case uri.Prefix != "" && uri.Prefix != "/":
+		return uri.Prefix + "*"
+	}
+
+	return ""
+}
+
+/*
+	VirtualServices routing the given host through the Gateway of a target,
+	ordered by namespace and name so the path rules are stable.
+*/
+func (d *Director) targetVirtualServices(target TerminationTarget, host string) []*istioApiv1alpha3.VirtualService {
+	if d.VirtualServiceInformer == nil {
+		return nil
+	}
+
+	all, err := d.VirtualServiceInformer.Lister().List(labels.Everything())
+	if err != nil {
+		zap.S().Error(err)
+		return nil
+	}
+
+	gatewayKey := target.gatewayKey()
+	services := []*istioApiv1alpha3.VirtualService{}
+	for _, vs := range all {
+		if !contains(virtualServiceGateways(vs), gatewayKey) {
+			continue
+		}
+		for _, pattern := range vs.Spec.Hosts {
+			if hostMatches(pattern, host) {
+				services = append(services, vs)
+				break
+			}
+		}
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	return services
+}
+
+/*
+	URL path map for a listener, built from the URI matches of the VirtualServices
+	routing its host. Paths go to the backend pool and HTTP settings annotated on
+	the VirtualService, everything else to those of the target. Returns nil when
+	there are no paths, the listener then gets a Basic rule.
+*/
+func (d *Director) targetURLPathMap(waf *azureNetwork.ApplicationGateway, name string, target TerminationTarget, host string) *azureNetwork.ApplicationGatewayURLPathMap {
+	pathRules := []azureNetwork.ApplicationGatewayPathRule{}
+	addedPaths := make([]string, 0)
+
+	for _, vs := range d.targetVirtualServices(target, host) {
+		pool := annotationOr(vs.Annotations, AnnotationBackendPool, target.Target)
+		settings := annotationOr(vs.Annotations, AnnotationHTTPSettings, target.BackendHttpSettings)
+
+		for i, route := range vs.Spec.HTTP {
+			paths := []string{}
+			for _, match := range route.Match {
+				path := uriPath(match)
+				if path == "" {
+					zap.S().Debugf("Skipping URI match of %s/%s, not expressible as an AG path", vs.Namespace, vs.Name)
+					continue
+				}
+				if contains(addedPaths, path) {
+					zap.S().Debugf("Skipping duplicate path %s for %s", path, host)
+					continue
+				}
+				addedPaths = append(addedPaths, path)
+				paths = append(paths, path)
+			}
+
+			if len(paths) == 0 {
+				continue
+			}
+
+			pathRules = append(pathRules, azureNetwork.ApplicationGatewayPathRule{
+				Name: to.StringPtr(pathRuleName(vs, i)),
+				ApplicationGatewayPathRulePropertiesFormat: &azureNetwork.ApplicationGatewayPathRulePropertiesFormat{
+					Paths:               &paths,
+					BackendAddressPool:  resourceRef(fmt.Sprintf("%s/backendAddressPools/%s", *waf.ID, pool)),
+					BackendHTTPSettings: resourceRef(fmt.Sprintf("%s/backendHttpSettingsCollection/%s", *waf.ID, settings)),
+				},
+			})
+		}
+	}
+
+	if len(pathRules) == 0 {
+		return nil
+	}
+
+	return &azureNetwork.ApplicationGatewayURLPathMap{
+		Name: to.StringPtr(name),
+		ApplicationGatewayURLPathMapPropertiesFormat: &azureNetwork.ApplicationGatewayURLPathMapPropertiesFormat{
+			DefaultBackendAddressPool:  resourceRef(fmt.Sprintf("%s/backendAddressPools/%s", *waf.ID, target.Target)),
+			DefaultBackendHTTPSettings: resourceRef(fmt.Sprintf("%s/backendHttpSettingsCollection/%s", *waf.ID, target.BackendHttpSettings)),
+			PathRules:                  &pathRules,
+		},
+	}
+}
+
+/*
+	Name of the path rule for a route of a VirtualService. Kubernetes names can't
+	contain underscores, so joined with those the names can't collide.
+*/
+func pathRuleName(vs *istioApiv1alpha3.VirtualService, route int) string {
+	return resourceName(strings.Join([]string{vs.Namespace, vs.Name, strconv.Itoa(route)}, "_"))
+}
+
+func (d *Director) targetPathBasedRoutingRule(waf *azureNetwork.ApplicationGateway, listener azureNetwork.ApplicationGatewayHTTPListener, pathMap azureNetwork.ApplicationGatewayURLPathMap) azureNetwork.ApplicationGatewayRequestRoutingRule {
+	return azureNetwork.ApplicationGatewayRequestRoutingRule{
+		Name: to.StringPtr(*listener.Name),
+		ApplicationGatewayRequestRoutingRulePropertiesFormat: &azureNetwork.ApplicationGatewayRequestRoutingRulePropertiesFormat{
+			RuleType:     azureNetwork.PathBasedRouting,
+			HTTPListener: resourceRef(fmt.Sprintf("%s/httpListeners/%s", *waf.ID, *listener.Name)),
+			URLPathMap:   resourceRef(fmt.Sprintf("%s/urlPathMaps/%s", *waf.ID, *pathMap.Name)),
+		},
+	}
+}
+
+func (d *Director) urlPathMapsToSync(waf *azureNetwork.ApplicationGateway) []azureNetwork.ApplicationGatewayURLPathMap {
+	pathMaps := []azureNetwork.ApplicationGatewayURLPathMap{}
+	if waf.URLPathMaps == nil {
+		return pathMaps
+	}
+
+	for _, pathMap := range *waf.URLPathMaps {
+		if !d.hasPrefix(*pathMap.Name) {
+			pathMaps = append(pathMaps, pathMap)
+		} else {
+			zap.S().Debugf("Skipping url path map %s", *pathMap.Name)
+		}
+	}
+
+	return pathMaps
+}
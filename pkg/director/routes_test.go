@@ -0,0 +1,134 @@
+package director
+
+import (
+	"context"
+	"testing"
+
+	azureNetwork "github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-12-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	istioApiv1alpha1 "knative.dev/pkg/apis/istio/common/v1alpha1"
+
+	istioFake "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned/fake"
+	istioInformers "github.com/evry-bergen/waf-syncer/pkg/clients/istio/informers/externalversions"
+)
+
+func testVirtualService(namespace string, name string, gateways []string, hosts []string, prefixes ...string) *istioApiv1alpha3.VirtualService {
+	routes := []istioApiv1alpha3.HTTPRoute{}
+	for _, prefix := range prefixes {
+		routes = append(routes, istioApiv1alpha3.HTTPRoute{
+			Match: []istioApiv1alpha3.HTTPMatchRequest{{URI: &istioApiv1alpha1.StringMatch{Prefix: prefix}}},
+		})
+	}
+
+	return &istioApiv1alpha3.VirtualService{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       istioApiv1alpha3.VirtualServiceSpec{Gateways: gateways, Hosts: hosts, HTTP: routes},
+	}
+}
+
+/*
+	Serve the given VirtualServices from an informer cache that is never started
+*/
+func withVirtualServices(d *Director, services ...*istioApiv1alpha3.VirtualService) {
+	factory := istioInformers.NewSharedInformerFactory(istioFake.NewSimpleClientset(), 0)
+	d.VirtualServiceInformer = factory.Networking().V1alpha3().VirtualServices()
+	for _, vs := range services {
+		d.VirtualServiceInformer.Informer().GetIndexer().Add(vs)
+	}
+}
+
+func TestVirtualServiceGateways_should_resolve_namespaces(t *testing.T) {
+	vs := testVirtualService("apps", "vs", []string{"mesh", "gw", "istio-system/ingress"}, nil)
+
+	assert.Equal(t, virtualServiceGateways(vs), []string{"apps/gw", "istio-system/ingress"})
+}
+
+func TestUriPath_should_convert_matches(t *testing.T) {
+	match := func(uri istioApiv1alpha1.StringMatch) istioApiv1alpha3.HTTPMatchRequest {
+		return istioApiv1alpha3.HTTPMatchRequest{URI: &uri}
+	}
+
+	assert.Equal(t, uriPath(match(istioApiv1alpha1.StringMatch{Prefix: "/api/"})), "/api/*")
+	assert.Equal(t, uriPath(match(istioApiv1alpha1.StringMatch{Exact: "/health"})), "/health")
+	assert.Equal(t, uriPath(match(istioApiv1alpha1.StringMatch{Prefix: "/"})), "", "catch-all is the path map default")
+	assert.Equal(t, uriPath(match(istioApiv1alpha1.StringMatch{Regex: "/v[0-9]+"})), "")
+	assert.Equal(t, uriPath(istioApiv1alpha3.HTTPMatchRequest{}), "")
+}
+
+func TestHostMatches(t *testing.T) {
+	assert.Equal(t, hostMatches("*", "a.example.com"), true)
+	assert.Equal(t, hostMatches("*.example.com", "a.example.com"), true)
+	assert.Equal(t, hostMatches("*.example.com", "example.com"), false)
+	assert.Equal(t, hostMatches("b.example.com", "a.example.com"), false)
+}
+
+func TestDirector_Reconcile_should_create_path_based_rules(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	api := testVirtualService("ns", "api", []string{"gw"}, []string{"a.example.com"}, "/api/")
+	api.Annotations = map[string]string{AnnotationBackendPool: "api-pool"}
+	withVirtualServices(d,
+		api,
+		testVirtualService("ns", "other-gateway", []string{"other"}, []string{"a.example.com"}, "/other/"),
+		testVirtualService("ns", "other-host", []string{"gw"}, []string{"b.example.com"}, "/b/"),
+	)
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	pools := append(*waf.BackendAddressPools, azureNetwork.ApplicationGatewayBackendAddressPool{Name: to.StringPtr("api-pool")})
+	waf.BackendAddressPools = &pools
+	agClient.Add("rg", waf)
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1)
+
+	waf, _ = agClient.Get(context.Background(), "rg", "ag")
+	rule := (*waf.RequestRoutingRules)[0]
+	assert.Equal(t, rule.RuleType, azureNetwork.PathBasedRouting)
+	assert.Equal(t, len(*waf.URLPathMaps), 1)
+
+	pathMap := (*waf.URLPathMaps)[0]
+	assert.Equal(t, *rule.URLPathMap.ID, *pathMap.ID)
+	assert.Equal(t, *pathMap.DefaultBackendAddressPool.ID, *waf.ID+"/backendAddressPools/pool")
+	assert.Equal(t, len(*pathMap.PathRules), 1)
+
+	pathRule := (*pathMap.PathRules)[0]
+	assert.Equal(t, *pathRule.Paths, []string{"/api/*"})
+	assert.Equal(t, *pathRule.BackendAddressPool.ID, *waf.ID+"/backendAddressPools/api-pool")
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, agClient.Updates(), 1, "unchanged path maps should not update the AG")
+
+	withVirtualServices(d)
+	assert.Equal(t, d.reconcile(), nil)
+
+	waf, _ = agClient.Get(context.Background(), "rg", "ag")
+	assert.Equal(t, (*waf.RequestRoutingRules)[0].RuleType, azureNetwork.Basic)
+	assert.Equal(t, len(*waf.URLPathMaps), 0)
+}
+
+func TestPathRuleName_should_not_collide(t *testing.T) {
+	a := testVirtualService("a-b", "c", nil, nil)
+	b := testVirtualService("a", "b-c", nil, nil)
+
+	assert.Equal(t, pathRuleName(a, 0), "a-b_c_0")
+	assert.Equal(t, pathRuleName(a, 0) == pathRuleName(b, 0), false)
+}
+
+func TestDirector_SyncTargetsToWAF_multi_host_listener_should_keep_path_based_hosts_apart(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com", "b.example.com", "c.example.com"))
+	d.AzureWafConfig.MultiHostListeners = true
+	withVirtualServices(d, testVirtualService("ns", "api", []string{"gw"}, []string{"b.example.com"}, "/api/"))
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com", "b.example.com", "c.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 2)
+	assert.Equal(t, *(*waf.HTTPListeners)[0].Name, "wd-b.example.com-tls")
+	assert.Equal(t, *(*waf.HTTPListeners)[1].HostNames, []string{"a.example.com", "c.example.com"})
+	assert.Equal(t, len(*waf.URLPathMaps), 1)
+	assert.Equal(t, *(*waf.URLPathMaps)[0].Name, "wd-b.example.com-tls")
+}
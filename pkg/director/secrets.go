@@ -0,0 +1,176 @@
+package director
+
+import (
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+type secretWatch struct {
+	informer cache.SharedIndexInformer
+	names    map[string]bool
+	stop     chan struct{}
+}
+
+/*
+	secretWatcher - Watches the secrets referenced by targets with one watch per
+	namespace that has any. A field selector can only match a single name, so
+	watching each secret by name would take a List+Watch stream per secret. The
+	namespace watches still receive every secret of their namespace, but drop
+	the unreferenced ones before the cache, so no other private key is kept in
+	memory. A watch is restarted, and its namespace listed again, when the
+	secrets referenced in its namespace change. Until it is started, or while a
+	watch is still syncing, secrets are read from the API.
+*/
+type secretWatcher struct {
+	client   kubernetes.Interface
+	onChange func(obj interface{})
+
+	lock    sync.Mutex
+	stop    <-chan struct{}
+	watches map[string]*secretWatch
+}
+
+// newSecretWatcher - Watcher calling onChange when a watched secret is created, changed or deleted
+func newSecretWatcher(client kubernetes.Interface, onChange func(obj interface{})) *secretWatcher {
+	return &secretWatcher{client: client, onChange: onChange, watches: map[string]*secretWatch{}}
+}
+
+// start - Watch the referenced secrets from now on, until stop is closed
+func (w *secretWatcher) start(stop <-chan struct{}) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.stop = stop
+	go func() {
+		<-stop
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		w.stop = nil
+		for namespace, existing := range w.watches {
+			close(existing.stop)
+			delete(w.watches, namespace)
+		}
+	}()
+}
+
+func (w *secretWatcher) newWatch(namespace string, names map[string]bool) *secretWatch {
+	referenced := func(event watch.Event) (watch.Event, bool) {
+		secret, ok := event.Object.(*v1.Secret)
+		return event, !ok || names[secret.Name]
+	}
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list, err := w.client.CoreV1().Secrets(namespace).List(options)
+			if err != nil {
+				return nil, err
+			}
+
+			items := []v1.Secret{}
+			for _, secret := range list.Items {
+				if names[secret.Name] {
+					items = append(items, secret)
+				}
+			}
+			list.Items = items
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			secrets, err := w.client.CoreV1().Secrets(namespace).Watch(options)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(secrets, referenced), nil
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(listWatch, &v1.Secret{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(secret interface{}) {
+			// The initial list, the secret was just read from the API anyway
+			if informer.HasSynced() {
+				w.onChange(secret)
+			}
+		},
+		UpdateFunc: func(oldSecret, newSecret interface{}) {
+			if oldSecret.(*v1.Secret).ResourceVersion != newSecret.(*v1.Secret).ResourceVersion {
+				w.onChange(newSecret)
+			}
+		},
+		DeleteFunc: func(secret interface{}) {
+			w.onChange(secret)
+		},
+	})
+
+	return &secretWatch{informer: informer, names: names, stop: make(chan struct{})}
+}
+
+/*
+	Watch the secrets of the targets and stop watching all others. Does nothing
+	before start.
+*/
+func (w *secretWatcher) sync(targets []TerminationTarget) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.stop == nil {
+		return
+	}
+
+	referenced := map[string]map[string]bool{}
+	for _, target := range targets {
+		if referenced[target.Namespace] == nil {
+			referenced[target.Namespace] = map[string]bool{}
+		}
+		referenced[target.Namespace][target.Secret] = true
+	}
+
+	for namespace, existing := range w.watches {
+		if !reflect.DeepEqual(existing.names, referenced[namespace]) {
+			zap.S().Debugf("Stop watching secrets of namespace %s", namespace)
+			close(existing.stop)
+			delete(w.watches, namespace)
+		}
+	}
+
+	for namespace, names := range referenced {
+		if _, ok := w.watches[namespace]; ok {
+			continue
+		}
+
+		zap.S().Debugf("Start watching %d secret(s) of namespace %s", len(names), namespace)
+		added := w.newWatch(namespace, names)
+		w.watches[namespace] = added
+		go added.informer.Run(added.stop)
+	}
+}
+
+// get - The secret from its watch once synced, otherwise from the API
+func (w *secretWatcher) get(namespace string, name string) (*v1.Secret, error) {
+	w.lock.Lock()
+	existing, ok := w.watches[namespace]
+	w.lock.Unlock()
+
+	if !ok || !existing.names[name] || !existing.informer.HasSynced() {
+		return w.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	}
+
+	obj, exists, err := existing.informer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apiErrors.NewNotFound(v1.Resource("secrets"), name)
+	}
+
+	return obj.(*v1.Secret), nil
+}
@@ -0,0 +1,130 @@
+package director
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	v1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8sFake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDirector_SyncTargetsToWAF_should_accept_opaque_secrets(t *testing.T) {
+	secret := testTLSSecret(t, "ns", "cert", "a.example.com")
+	secret.Type = v1.SecretTypeOpaque
+	d, agClient := testReconcileDirector(secret)
+	d.add(testGateway("ns", "gw", testTLSServer("cert", "a.example.com")))
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	d.syncTargetsToWAF(&waf)
+
+	assert.Equal(t, len(*waf.HTTPListeners), 1)
+	assert.Equal(t, len(*waf.SslCertificates), 1)
+}
+
+func watchedSecrets(w *secretWatcher) []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	keys := []string{}
+	for namespace, existing := range w.watches {
+		for name := range existing.names {
+			keys = append(keys, namespace+"/"+name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func waitForSecretWatch(t *testing.T, w *secretWatcher, namespace string) cache.Store {
+	var store cache.Store
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		existing, ok := w.watches[namespace]
+		if ok && existing.informer.HasSynced() {
+			store = existing.informer.GetStore()
+		}
+		return store != nil, nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for the secret watch of %s: %s", namespace, err)
+	}
+
+	return store
+}
+
+// Number of list and watch requests for secrets, one of each per started watch
+func secretStreams(client *k8sFake.Clientset) int {
+	streams := 0
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == "secrets" && (action.GetVerb() == "list" || action.GetVerb() == "watch") {
+			streams++
+		}
+	}
+	return streams
+}
+
+func TestSecretWatcher_should_only_watch_referenced_secrets(t *testing.T) {
+	d, _ := testReconcileDirector(
+		testTLSSecret(t, "ns", "a", "a.example.com"),
+		testTLSSecret(t, "ns", "b", "b.example.com"),
+		testTLSSecret(t, "ns", "unused", "unused.example.com"),
+		testTLSSecret(t, "other", "c", "c.example.com"))
+	client := d.ClientSet.(*k8sFake.Clientset)
+	gw := testGateway("ns", "gw", testTLSServer("a", "a.example.com"), testTLSServer("b", "b.example.com"))
+	d.add(gw)
+	d.add(testGateway("other", "gw", testTLSServer("c", "c.example.com")))
+
+	d.secrets.sync(d.Targets.Snapshot())
+	assert.Equal(t, watchedSecrets(d.secrets), []string{}, "nothing should be watched before start")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	d.secrets.start(stop)
+
+	d.secrets.sync(d.Targets.Snapshot())
+	assert.Equal(t, watchedSecrets(d.secrets), []string{"ns/a", "ns/b", "other/c"})
+	store := waitForSecretWatch(t, d.secrets, "ns")
+	waitForSecretWatch(t, d.secrets, "other")
+	cached := store.ListKeys()
+	sort.Strings(cached)
+	assert.Equal(t, cached, []string{"ns/a", "ns/b"}, "the unused secret should not be cached")
+	assert.Equal(t, secretStreams(client), 4, "one list and watch per namespace")
+
+	d.update(gw, testGateway("ns", "gw", testTLSServer("a", "a.example.com"), testTLSServer("missing", "b.example.com")))
+	d.secrets.sync(d.Targets.Snapshot())
+	assert.Equal(t, watchedSecrets(d.secrets), []string{"ns/a", "ns/missing", "other/c"})
+	store = waitForSecretWatch(t, d.secrets, "ns")
+	assert.Equal(t, secretStreams(client), 6, "only the changed namespace should be watched again")
+
+	secret, err := d.secrets.get("ns", "a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, secret.Name, "a")
+
+	_, err = d.secrets.get("ns", "missing")
+	assert.Equal(t, apiErrors.IsNotFound(err), true)
+
+	// Watch events arrive in order, once a is updated the unused update was dropped
+	unused, _ := client.CoreV1().Secrets("ns").Get("unused", metav1.GetOptions{})
+	unused.ResourceVersion = "2"
+	client.CoreV1().Secrets("ns").Update(unused)
+	a, _ := client.CoreV1().Secrets("ns").Get("a", metav1.GetOptions{})
+	a.ResourceVersion = "2"
+	client.CoreV1().Secrets("ns").Update(a)
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		secret, err := d.secrets.get("ns", "a")
+		return err == nil && secret.ResourceVersion == "2", nil
+	})
+	if err != nil {
+		t.Fatalf("waiting for the update of secret a: %s", err)
+	}
+	assert.Equal(t, store.ListKeys(), []string{"ns/a"})
+}
@@ -0,0 +1,203 @@
+package director
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AnnotationStatus - Gateway annotation the syncer writes its GatewayStatus to
+const AnnotationStatus = "waf-syncer.evry.com/status"
+
+/*
+	GatewayStatus - What is live on the WAF for a Gateway. Istio Gateways have no
+	status, so it is kept as JSON in an annotation. It only changes when the sync
+	outcome does, there are deliberately no timestamps.
+*/
+type GatewayStatus struct {
+	ApplicationGateway string              `json:"applicationGateway"`
+	Listeners          []string            `json:"listeners"`
+	Certificates       []CertificateStatus `json:"certificates"`
+	ObservedGeneration int64               `json:"observedGeneration"`
+	Error              string              `json:"error,omitempty"`
+}
+
+// CertificateStatus - A certificate on the WAF, with the SHA-256 thumbprint and expiry of its leaf
+type CertificateStatus struct {
+	Name       string    `json:"name"`
+	Thumbprint string    `json:"thumbprint"`
+	NotAfter   time.Time `json:"notAfter"`
+}
+
+/*
+	Whether an update of a Gateway only touched our status annotation. Those are
+	our own writes and must not trigger another sync.
+*/
+func statusOnlyUpdate(old interface{}, new interface{}) bool {
+	oldGw, ok := old.(*istioApiv1alpha3.Gateway)
+	if !ok {
+		return false
+	}
+	newGw, ok := new.(*istioApiv1alpha3.Gateway)
+	if !ok || oldGw.ResourceVersion == newGw.ResourceVersion {
+		return false
+	}
+
+	if oldGw.Annotations[AnnotationStatus] == newGw.Annotations[AnnotationStatus] {
+		return false
+	}
+
+	withoutStatus := func(annotations map[string]string) map[string]string {
+		copied := map[string]string{}
+		for key, value := range annotations {
+			if key != AnnotationStatus {
+				copied[key] = value
+			}
+		}
+		return copied
+	}
+
+	return reflect.DeepEqual(oldGw.Spec, newGw.Spec) &&
+		reflect.DeepEqual(oldGw.Labels, newGw.Labels) &&
+		reflect.DeepEqual(withoutStatus(oldGw.Annotations), withoutStatus(newGw.Annotations))
+}
+
+func (d *Director) gatewayStatus(targets []TerminationTarget, result *syncResult) GatewayStatus {
+	status := GatewayStatus{
+		ApplicationGateway: d.AzureWafConfig.Name,
+		Listeners:          []string{},
+		Certificates:       []CertificateStatus{},
+		Error:              strings.Join(result.Errors[targets[0].gatewayKey()], "; "),
+	}
+
+	for _, target := range targets {
+		if target.Generation > status.ObservedGeneration {
+			status.ObservedGeneration = target.Generation
+		}
+
+		name := result.Certificates[target.secretKey()]
+		fingerprint, ok := result.CertificateFingerprints[name]
+		if !ok || containsCertificate(status.Certificates, name) {
+			continue
+		}
+		status.Certificates = append(status.Certificates, CertificateStatus{
+			Name:       name,
+			Thumbprint: fingerprint,
+			NotAfter:   result.CertificateExpiry[name].UTC(),
+		})
+	}
+
+	for name, owner := range result.Owners {
+		_, certificate := result.CertificateFingerprints[name]
+		if !certificate && owner.gatewayKey() == targets[0].gatewayKey() {
+			status.Listeners = append(status.Listeners, name)
+		}
+	}
+	sort.Strings(status.Listeners)
+
+	return status
+}
+
+/*
+	Write the outcome of a sync to the status annotation of every Gateway it had
+	targets of, with the generation it saw. When the AG update failed the
+	Gateways it would have changed keep their previous listeners, certificates
+	and generation, those are still what is live.
+
+	Gateways with a status but no targets left had all their TLS servers
+	removed, their status is emptied once their resources are pruned.
+*/
+func (d *Director) writeStatus(changes *ChangeSet, result *syncResult, updateErr error) {
+	if d.IstioClient == nil || d.GatewayInformer == nil {
+		return
+	}
+
+	_, affected := changeCounts(changes, result.Owners)
+	byGateway := map[string][]TerminationTarget{}
+	for _, target := range result.Targets {
+		byGateway[target.gatewayKey()] = append(byGateway[target.gatewayKey()], target)
+	}
+
+	gateways, err := d.GatewayInformer.Lister().List(labels.Everything())
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+
+	for _, gw := range gateways {
+		key := fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)
+		targets, synced := byGateway[key]
+		/* Servers added since the snapshot are written by the next sync */
+		if !synced && (gw.Annotations[AnnotationStatus] == "" || d.Targets.HasGateway(gw.Namespace, gw.Name)) {
+			continue
+		}
+
+		previous := GatewayStatus{Listeners: []string{}, Certificates: []CertificateStatus{}}
+		json.Unmarshal([]byte(gw.Annotations[AnnotationStatus]), &previous)
+
+		status := GatewayStatus{
+			ApplicationGateway: d.AzureWafConfig.Name,
+			Listeners:          []string{},
+			Certificates:       []CertificateStatus{},
+			ObservedGeneration: gw.Generation,
+		}
+		changed := len(previous.Listeners)+len(previous.Certificates) > 0
+		if synced {
+			status = d.gatewayStatus(targets, result)
+			_, changed = affected[key]
+		}
+
+		if changed && updateErr != nil {
+			status.Listeners = previous.Listeners
+			status.Certificates = previous.Certificates
+			status.ObservedGeneration = previous.ObservedGeneration
+			status.Error = strings.Join(append([]string{"updating WAF: " + updateErr.Error()}, result.Errors[key]...), "; ")
+		}
+		d.patchStatus(gw, status)
+	}
+}
+
+func (d *Director) patchStatus(gw *istioApiv1alpha3.Gateway, status GatewayStatus) {
+	key := fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)
+	raw, err := json.Marshal(status)
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+	if gw.Annotations[AnnotationStatus] == string(raw) {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{AnnotationStatus: string(raw)},
+		},
+	})
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+
+	zap.S().Debugf("Updating status of gateway %s", key)
+	_, err = d.IstioClient.NetworkingV1alpha3().Gateways(gw.Namespace).Patch(gw.Name, types.MergePatchType, patch)
+	if err != nil {
+		zap.S().Errorf("Unable to update status of gateway %s: %s", key, err)
+	}
+}
+
+func containsCertificate(certificates []CertificateStatus, name string) bool {
+	for _, certificate := range certificates {
+		if certificate.Name == name {
+			return true
+		}
+	}
+	return false
+}
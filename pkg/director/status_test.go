@@ -0,0 +1,192 @@
+package director
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"github.com/magiconair/properties/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTesting "k8s.io/client-go/testing"
+
+	istioFake "github.com/evry-bergen/waf-syncer/pkg/clients/istio/clientset/versioned/fake"
+	istioInformers "github.com/evry-bergen/waf-syncer/pkg/clients/istio/informers/externalversions"
+)
+
+/*
+	Serve the given Gateway from an informer cache that is never started, and
+	from a fake clientset recording the status patches
+*/
+func withGatewayStatus(d *Director, gw *istioApiv1alpha3.Gateway) *istioFake.Clientset {
+	client := istioFake.NewSimpleClientset()
+	client.NetworkingV1alpha3().Gateways(gw.Namespace).Create(gw)
+	factory := istioInformers.NewSharedInformerFactory(client, 0)
+	d.IstioClient = client
+	d.GatewayInformer = factory.Networking().V1alpha3().Gateways()
+	d.GatewayInformer.Informer().GetIndexer().Add(gw)
+
+	return client
+}
+
+func statusPatches(client *istioFake.Clientset) []GatewayStatus {
+	statuses := []GatewayStatus{}
+	for _, action := range client.Actions() {
+		patch, ok := action.(k8sTesting.PatchAction)
+		if !ok {
+			continue
+		}
+
+		var body struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		json.Unmarshal(patch.GetPatch(), &body)
+
+		status := GatewayStatus{}
+		json.Unmarshal([]byte(body.Metadata.Annotations[AnnotationStatus]), &status)
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func TestDirector_Reconcile_should_write_gateway_status(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	gw.Generation = 3
+	client := withGatewayStatus(d, gw)
+	d.add(gw)
+
+	agClient.UpdateError = errors.New("conflict")
+	assert.Equal(t, d.reconcile() != nil, true)
+
+	agClient.UpdateError = nil
+	assert.Equal(t, d.reconcile(), nil)
+
+	statuses := statusPatches(client)
+	assert.Equal(t, len(statuses), 2)
+	assert.Equal(t, statuses[0].Error, "updating WAF: conflict")
+	assert.Equal(t, len(statuses[0].Listeners), 0)
+	assert.Equal(t, statuses[0].ObservedGeneration, int64(0), "nothing of the Gateway was synced yet")
+
+	status := statuses[1]
+	assert.Equal(t, status.ApplicationGateway, "ag")
+	assert.Equal(t, status.Listeners, []string{"wd-a.example.com-tls"})
+	assert.Equal(t, len(status.Certificates), 1)
+	assert.Matches(t, status.Certificates[0].Name, "^wd-cert-[0-9a-f]{16}$")
+	assert.Equal(t, status.Certificates[0].Thumbprint != "", true)
+	assert.Equal(t, status.ObservedGeneration, int64(3))
+	assert.Equal(t, status.Error, "")
+}
+
+func TestDirector_WriteStatus_should_not_patch_unchanged_status(t *testing.T) {
+	d, _ := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	client := withGatewayStatus(d, gw)
+	d.add(gw)
+
+	assert.Equal(t, d.reconcile(), nil)
+	patched, err := client.NetworkingV1alpha3().Gateways("ns").Get("gw", metav1.GetOptions{})
+	assert.Equal(t, err, nil)
+	d.GatewayInformer.Informer().GetIndexer().Update(patched)
+	client.ClearActions()
+
+	assert.Equal(t, d.reconcile(), nil)
+	assert.Equal(t, len(statusPatches(client)), 0)
+}
+
+func TestStatusOnlyUpdate(t *testing.T) {
+	old := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	old.ResourceVersion = "1"
+
+	status := old.DeepCopy()
+	status.ResourceVersion = "2"
+	status.Annotations = map[string]string{AnnotationStatus: "{}"}
+	assert.Equal(t, statusOnlyUpdate(old, status), true)
+
+	resync := old.DeepCopy()
+	assert.Equal(t, statusOnlyUpdate(old, resync), false, "resyncs should heal drift")
+
+	annotated := status.DeepCopy()
+	annotated.Annotations[AnnotationHTTPSRedirect] = "true"
+	assert.Equal(t, statusOnlyUpdate(old, annotated), false)
+
+	spec := status.DeepCopy()
+	spec.Spec.Servers[0].Hosts = []string{"b.example.com"}
+	assert.Equal(t, statusOnlyUpdate(old, spec), false)
+}
+
+func TestDirector_WriteStatus_should_report_the_synced_generation(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	gw.Generation = 1
+	client := withGatewayStatus(d, gw)
+	d.add(gw)
+
+	waf, _ := agClient.Get(context.Background(), "rg", "ag")
+	changes, _, result := d.desiredChanges(&waf)
+
+	changed := gw.DeepCopy()
+	changed.Generation = 2
+	d.update(gw, changed)
+	d.writeStatus(changes, result, nil)
+
+	statuses := statusPatches(client)
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].ObservedGeneration, int64(1))
+}
+
+func TestDirector_WriteStatus_should_empty_the_status_of_gateways_without_targets(t *testing.T) {
+	d, agClient := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw", testTLSServer("cert", "a.example.com"))
+	gw.Generation = 1
+	client := withGatewayStatus(d, gw)
+	d.add(gw)
+	assert.Equal(t, d.reconcile(), nil)
+
+	synced, _ := client.NetworkingV1alpha3().Gateways("ns").Get("gw", metav1.GetOptions{})
+	removed := synced.DeepCopy()
+	removed.Generation = 2
+	removed.Spec.Servers = nil
+	d.GatewayInformer.Informer().GetIndexer().Update(removed)
+	d.update(synced, removed)
+	client.ClearActions()
+
+	agClient.UpdateError = errors.New("conflict")
+	assert.Equal(t, d.reconcile() != nil, true)
+	statuses := statusPatches(client)
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].Listeners, []string{"wd-a.example.com-tls"}, "the listener is still live")
+	assert.Equal(t, statuses[0].ObservedGeneration, int64(1))
+	assert.Equal(t, statuses[0].Error, "updating WAF: conflict")
+
+	agClient.UpdateError = nil
+	client.ClearActions()
+	assert.Equal(t, d.reconcile(), nil)
+	statuses = statusPatches(client)
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, len(statuses[0].Listeners), 0)
+	assert.Equal(t, len(statuses[0].Certificates), 0)
+	assert.Equal(t, statuses[0].ObservedGeneration, int64(2))
+	assert.Equal(t, statuses[0].Error, "")
+}
+
+func TestDirector_WriteStatus_should_report_every_error_of_a_gateway(t *testing.T) {
+	d, _ := testReconcileDirector(testTLSSecret(t, "ns", "cert", "a.example.com"))
+	gw := testGateway("ns", "gw",
+		testTLSServer("cert", "a.example.com", "b.example.com", "c.example.com"),
+		testTLSServer("missing", "d.example.com"))
+	client := withGatewayStatus(d, gw)
+	d.add(gw)
+
+	assert.Equal(t, d.reconcile(), nil)
+	statuses := statusPatches(client)
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].Listeners, []string{"wd-a.example.com-tls"})
+	assert.Equal(t, statuses[0].Error, "host b.example.com is not covered by the certificate of secret cert; "+
+		"host c.example.com is not covered by the certificate of secret cert; "+
+		`secret missing: secrets "missing" not found`)
+}
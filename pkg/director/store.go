@@ -0,0 +1,103 @@
+package director
+
+import (
+	"sort"
+	"sync"
+)
+
+type targetKey struct {
+	Namespace string
+	Gateway   string
+	Server    int
+}
+
+// TargetStore - TerminationTargets keyed by Gateway and server index, safe for concurrent use
+type TargetStore struct {
+	lock    sync.RWMutex
+	targets map[targetKey]TerminationTarget
+}
+
+// NewTargetStore - Creates an empty target store
+func NewTargetStore() *TargetStore {
+	return &TargetStore{
+		targets: map[targetKey]TerminationTarget{},
+	}
+}
+
+// Replace - Replace all targets of a Gateway with the given ones
+func (s *TargetStore) Replace(namespace string, gateway string, targets []TerminationTarget) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deleteLocked(namespace, gateway)
+	for _, target := range targets {
+		s.targets[targetKey{Namespace: namespace, Gateway: gateway, Server: target.Server}] = target
+	}
+}
+
+// Delete - Remove all targets of a Gateway
+func (s *TargetStore) Delete(namespace string, gateway string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deleteLocked(namespace, gateway)
+}
+
+func (s *TargetStore) deleteLocked(namespace string, gateway string) {
+	for key := range s.targets {
+		if key.Namespace == namespace && key.Gateway == gateway {
+			delete(s.targets, key)
+		}
+	}
+}
+
+// HasGateway - Whether the Gateway has any targets
+func (s *TargetStore) HasGateway(namespace string, gateway string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for key := range s.targets {
+		if key.Namespace == namespace && key.Gateway == gateway {
+			return true
+		}
+	}
+	return false
+}
+
+// Len - Number of targets in the store
+func (s *TargetStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.targets)
+}
+
+// Snapshot - A consistent copy of all targets, ordered by Gateway and server index
+func (s *TargetStore) Snapshot() []TerminationTarget {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	keys := make([]targetKey, 0, len(s.targets))
+	for key := range s.targets {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		if keys[i].Gateway != keys[j].Gateway {
+			return keys[i].Gateway < keys[j].Gateway
+		}
+		return keys[i].Server < keys[j].Server
+	})
+
+	targets := make([]TerminationTarget, 0, len(keys))
+	for _, key := range keys {
+		target := s.targets[key]
+		target.Hosts = append([]string(nil), target.Hosts...)
+		targets = append(targets, target)
+	}
+
+	return targets
+}
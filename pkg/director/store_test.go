@@ -0,0 +1,50 @@
+package director
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestTargetStore_Snapshot_should_be_ordered(t *testing.T) {
+	s := NewTargetStore()
+	s.Replace("b", "gw", []TerminationTarget{{Namespace: "b", Gateway: "gw", Server: 0}})
+	s.Replace("a", "gw", []TerminationTarget{
+		{Namespace: "a", Gateway: "gw", Server: 1},
+		{Namespace: "a", Gateway: "gw", Server: 0},
+	})
+
+	snapshot := s.Snapshot()
+	assert.Equal(t, len(snapshot), 3)
+	assert.Equal(t, snapshot[0].Namespace, "a")
+	assert.Equal(t, snapshot[0].Server, 0)
+	assert.Equal(t, snapshot[1].Server, 1)
+	assert.Equal(t, snapshot[2].Namespace, "b")
+}
+
+func TestTargetStore_should_be_safe_for_concurrent_use(t *testing.T) {
+	s := NewTargetStore()
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Replace("ns", "gw", []TerminationTarget{{Namespace: "ns", Gateway: "gw", Hosts: []string{"a"}}})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for _, target := range s.Snapshot() {
+					target.Hosts[0] = "b"
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, s.Len(), 1)
+}
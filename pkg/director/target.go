@@ -2,21 +2,125 @@ package director
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	istioApiv1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+)
+
+const (
+	// AnnotationBackendPool - Gateway annotation overriding the AG backend pool
+	AnnotationBackendPool = "waf-syncer.evry.com/backend-pool"
+	// AnnotationHTTPSettings - Gateway annotation overriding the AG backend HTTP settings
+	AnnotationHTTPSettings = "waf-syncer.evry.com/http-settings"
+	// AnnotationFrontendPort - Gateway annotation overriding the AG frontend port
+	AnnotationFrontendPort = "waf-syncer.evry.com/frontend-port"
+	// AnnotationHTTPFrontendPort - Gateway annotation overriding the AG frontend port for HTTP redirects
+	AnnotationHTTPFrontendPort = "waf-syncer.evry.com/http-frontend-port"
+	// AnnotationHTTPSRedirect - Gateway annotation turning HTTP to HTTPS redirects on or off
+	AnnotationHTTPSRedirect = "waf-syncer.evry.com/https-redirect"
+	// AnnotationMultiHostListener - Gateway annotation turning listeners with several host names on or off
+	AnnotationMultiHostListener = "waf-syncer.evry.com/multi-host-listener"
 )
 
 type TerminationTarget struct {
-	Hosts     []string
-	Port      int
-	Secret    string
-	Namespace string
-	Target    string
+	Hosts               []string
+	Port                int
+	Secret              string
+	Namespace           string
+	Gateway             string
+	Generation          int64
+	Server              int
+	Target              string
+	BackendHttpSettings string
+	FrontendPort        string
+	HTTPFrontendPort    string
+	HTTPSRedirect       bool
+	MultiHostListener   bool
+}
+
+/*
+	The value of an annotation, or the fallback when it is missing or empty
+*/
+func annotationOr(annotations map[string]string, name string, fallback string) string {
+	if value := annotations[name]; value != "" {
+		return value
+	}
+	return fallback
+}
+
+const (
+	// Istio host of a server matching every host, synced as a basic listener
+	catchAllHost = "*"
+	// Most host names AG takes on a single listener
+	maxListenerHostNames = 5
+)
+
+/*
+	Host name or host names list of the listener for Istio hosts. A single plain
+	host is a host name, wildcards like *.example.com and several hosts need the
+	list. The catch-all host gets a basic listener without either, AG uses it for
+	requests no multi-site listener matches.
+*/
+func listenerHosts(hosts []string) (*string, *[]string) {
+	if len(hosts) == 1 && hosts[0] == catchAllHost {
+		return nil, nil
+	}
+	if len(hosts) == 1 && !wildcardHost(hosts[0]) {
+		return to.StringPtr(hosts[0]), nil
+	}
+
+	names := append([]string{}, hosts...)
+	return nil, &names
 }
 
-func (t TerminationTarget) generateNameWithPrefix(prefix string, hostname string) string {
-	name := fmt.Sprintf("%s-%s", prefix, hostname)
-	return name
+// Whether the host is a wildcard like *.example.com
+func wildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*.")
 }
 
-func (t TerminationTarget) generateSecretName(prefix string) string {
-	return fmt.Sprintf("%s-%s-%s", prefix, t.Namespace, t.Secret)
+func (t TerminationTarget) gatewayKey() string {
+	return fmt.Sprintf("%s/%s", t.Namespace, t.Gateway)
+}
+
+func (t TerminationTarget) secretKey() string {
+	return fmt.Sprintf("%s/%s", t.Namespace, t.Secret)
+}
+
+/*
+	Whether the hosts of a Gateway server share listeners with a host names list.
+	The annotation overrides --multi-host-listeners.
+*/
+func multiHostListener(gw *istioApiv1alpha3.Gateway, fallback bool) bool {
+	if value, err := strconv.ParseBool(gw.Annotations[AnnotationMultiHostListener]); err == nil {
+		return value
+	}
+	return fallback
+}
+
+/*
+	Whether a server terminates TLS with a secret. The plain HTTP server carrying
+	tls.httpsRedirect has TLS options too, but no credential to sync.
+*/
+func terminatesTLS(srv istioApiv1alpha3.Server) bool {
+	return srv.TLS != nil && srv.TLS.CredentialName != ""
+}
+
+/*
+	Whether the hosts of a Gateway should redirect HTTP to HTTPS. Istio asks for
+	this with tls.httpsRedirect on a server, the annotation overrides it.
+*/
+func httpsRedirect(gw *istioApiv1alpha3.Gateway) bool {
+	if value, err := strconv.ParseBool(gw.Annotations[AnnotationHTTPSRedirect]); err == nil {
+		return value
+	}
+
+	for _, srv := range gw.Spec.Servers {
+		if srv.TLS != nil && srv.TLS.HTTPSRedirect {
+			return true
+		}
+	}
+
+	return false
 }